@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// applyObject server-side applies obj, a pod or service with its type meta
+// set, as the object of resource name with the field manager. A conflict with
+// another manager, e.g. another kubernoisy instance using the same name, is
+// counted, and retried forcing ownership if ssa-force is set.
+func applyObject(ctx context.Context, kapi kubernetes.Interface, ns, resource, name string, obj runtime.Object) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	err = applyPatch(ctx, kapi, ns, resource, name, data, false)
+	if errors.IsConflict(err) {
+		SSAConflictCount.WithLabelValues(resource).Inc()
+		logEvent(logFields{action: "apply", object: resource, name: name, namespace: ns, err: err}, "conflict applying %v %v.%v as %v: %v", resource, name, ns, fieldManager, err)
+		if ssaForce {
+			err = applyPatch(ctx, kapi, ns, resource, name, data, true)
+		}
+	}
+	return err
+}
+
+// applyPatch sends the apply patch data for the object of resource name.
+func applyPatch(ctx context.Context, kapi kubernetes.Interface, ns, resource, name string, data []byte, force bool) error {
+	req := kapi.CoreV1().RESTClient().Patch(types.ApplyPatchType).
+		Namespace(ns).
+		Resource(resource).
+		Name(name).
+		Param("fieldManager", fieldManager).
+		Body(data)
+	if force {
+		req = req.Param("force", "true")
+	}
+	return req.Do(ctx).Error()
+}
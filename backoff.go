@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// errorBackoff holds back new operations while operations keep failing, so
+// that a broken run does not hammer the cluster needlessly.
+var errorBackoff backoff
+
+type backoff struct {
+	mu       sync.Mutex
+	failures int
+	until    time.Time
+}
+
+// record records the outcome of an operation, extending the backoff after
+// consecutive failures and resetting it on success.
+func (b *backoff) record(ok bool) {
+	if errorBackoffBase == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		b.failures = 0
+		b.until = time.Time{}
+		ErrorBackoff.Set(0)
+		return
+	}
+	b.failures++
+	if b.failures < errorBackoffAfter {
+		return
+	}
+	delay := errorBackoffBase
+	for i := errorBackoffAfter; i < b.failures && delay < errorBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > errorBackoffMax {
+		delay = errorBackoffMax
+	}
+	b.until = clock.Now().Add(delay)
+	ErrorBackoff.Set(delay.Seconds())
+}
+
+// active returns true if new operations should not be started yet.
+func (b *backoff) active() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return clock.Now().Before(b.until)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// A batch collects the add propagation delays of cycles started together.
+type batch struct {
+	mu     sync.Mutex
+	delays []time.Duration
+}
+
+// observe adds the add propagation delay of a cycle. It is a no-op on a nil batch.
+func (b *batch) observe(d time.Duration) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.delays = append(b.delays, d)
+	b.mu.Unlock()
+}
+
+// runBatch runs batchSize cycles concurrently, modelling a deploy-time burst,
+// and records the duration of the whole batch and the spread of the add
+// propagation delays within it. It returns false if every cycle failed.
+func runBatch(kapi kubernetes.Interface, w *workload, cycle func(kubernetes.Interface, *workload, *batch) bool) bool {
+	b := &batch{}
+	var wg sync.WaitGroup
+	var succeeded int32
+	start := clock.Now()
+	for i := 0; i < batchSize; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cycle(kapi, w, b) {
+				atomic.AddInt32(&succeeded, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	BatchDuration.Observe(clock.Since(start).Seconds())
+
+	if len(b.delays) == 0 {
+		return succeeded > 0
+	}
+	min, max := b.delays[0], b.delays[0]
+	for _, d := range b.delays[1:] {
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+	BatchSpread.Observe((max - min).Seconds())
+	return succeeded > 0
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math/rand"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// chaosRoll returns true with the chaos probability.
+func chaosRoll() bool {
+	return chaos > 0 && rand.Float64() < chaos
+}
+
+// chaosOrder returns order swapped between first and second with the chaos
+// probability, counting the swap under action.
+func chaosOrder(action, first, second string) string {
+	if !chaosRoll() {
+		return first
+	}
+	ChaosCount.WithLabelValues(action, second).Inc()
+	return second
+}
+
+// pickDeleteOrder returns the order to delete the pods and service of a
+// cycle in: pods-first, or with chaos, at random service-first.
+func pickDeleteOrder() string {
+	return chaosOrder("delete-order", "pod-first", "service-first")
+}
+
+// deleteServiceObjects deletes the pods and headless service name in order,
+// pod-first or service-first, returning false if any could not be deleted.
+func deleteServiceObjects(kapi kubernetes.Interface, ns, name, order string) bool {
+	if order == "service-first" {
+		return deleteService(kapi, ns, name) && deletePods(kapi, ns, name)
+	}
+	return deletePods(kapi, ns, name) && deleteService(kapi, ns, name)
+}
+
+// chaosCollide creates the service name again, which the API server must
+// reject as already existing, counting the outcome: rejected, or accepted if
+// a duplicate was created, or error.
+func chaosCollide(kapi kubernetes.Interface, ns, name string) {
+	ctx, cancel := apiContext()
+	defer cancel()
+	_, err := createServiceObject(ctx, kapi, newService(ns, name))
+	switch {
+	case errors.IsAlreadyExists(err):
+		ChaosCount.WithLabelValues("collision", "rejected").Inc()
+	case err == nil:
+		ChaosCount.WithLabelValues("collision", "accepted").Inc()
+		logEvent(logFields{action: "collision", object: "service", name: name, namespace: ns}, "duplicate service %v.%v was created", name, ns)
+	default:
+		ChaosCount.WithLabelValues("collision", "error").Inc()
+		logEvent(logFields{action: "collision", object: "service", name: name, namespace: ns, err: err}, "could not collide with service %v.%v: %v", name, ns, err)
+	}
+}
+
+// chaosRecreate creates the service name again right after it was deleted,
+// selecting its pods still terminating, and deletes it again at once, so that
+// DNS sees the name deleted, added and deleted in quick succession.
+func chaosRecreate(kapi kubernetes.Interface, ns, name string) {
+	if !createService(kapi, ns, name) {
+		ChaosCount.WithLabelValues("recreate", "error").Inc()
+		return
+	}
+	if !deleteService(kapi, ns, name) {
+		ChaosCount.WithLabelValues("recreate", "error").Inc()
+		return
+	}
+	ChaosCount.WithLabelValues("recreate", "done").Inc()
+}
+
+// chaosAbandon returns true with the chaos abandon probability, for a cycle to
+// leave its objects behind, to the object ttl collection or the cleanup on
+// exit.
+func chaosAbandon() bool {
+	if chaosAbandonRatio <= 0 || rand.Float64() >= chaosAbandonRatio {
+		return false
+	}
+	ChaosCount.WithLabelValues("abandon", "done").Inc()
+	return true
+}
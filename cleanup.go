@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// reapGracePeriod is the grace period, in seconds, of pods deleted when
+// reaping, short so that they do not linger terminating.
+const reapGracePeriod = 1
+
+// reapObjects deletes all objects labeled kubernoisy=noise in the namespaces,
+// e.g. left over from operations interrupted by a signal or a crash, and
+// returns how many were deleted.
+func reapObjects(kapi kubernetes.Interface) int {
+	return reapOlder(kapi, 0)
+}
+
+// reapOlder deletes the objects labeled kubernoisy=noise in the namespaces
+// created more than age ago, or all of them if 0, and returns how many were
+// deleted.
+func reapOlder(kapi kubernetes.Interface, age time.Duration) int {
+	n := 0
+	for _, ns := range allNamespaces() {
+		n += reapNamespace(kapi, ns, age)
+	}
+	return n
+}
+
+// reapable returns true if the object of meta is to be reaped by age: if
+// created more than age ago, or always if age is 0. The standing and
+// prepopulated services are expected to live for the whole run, so they are
+// only reaped regardless of age.
+func reapable(meta metav1.ObjectMeta, age time.Duration) bool {
+	if age == 0 {
+		return true
+	}
+	if strings.HasPrefix(meta.Name, standingPrefix) || strings.HasPrefix(meta.Name, prepopulatePrefix) {
+		return false
+	}
+	return clock.Since(meta.CreationTimestamp.Time) > age
+}
+
+// collectOrphans deletes, every interval, the objects older than the object
+// ttl, e.g. the leftovers of another instance that crashed.
+func collectOrphans(kapi kubernetes.Interface, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		if n := reapOlder(kapi, objectTTL); n > 0 {
+			OrphansCleanedCount.Add(float64(n))
+			logEvent(logFields{action: "cleanup"}, "Cleaned up %d objects older than %v", n, objectTTL)
+		}
+	}
+}
+
+// reapNamespace deletes the objects labeled kubernoisy=noise in the namespace
+// ns that are reapable by age, and returns how many were deleted. With
+// sharding, only the objects of this shard are reaped regardless of age.
+func reapNamespace(kapi kubernetes.Interface, ns string, age time.Duration) int {
+	grace := int64(reapGracePeriod)
+	propagation := metav1.DeletePropagationBackground
+	opts := metav1.DeleteOptions{GracePeriodSeconds: &grace, PropagationPolicy: &propagation}
+	// the leftovers of other shards are only reaped by age
+	sel := metav1.ListOptions{LabelSelector: "kubernoisy=noise"}
+	if age == 0 {
+		sel.LabelSelector = shardSelector()
+	}
+	n := 0
+
+	// controllers first, so that they do not replace the pods deleted after them
+	ctx, cancel := apiContext()
+	if l, err := kapi.AppsV1().Deployments(ns).List(ctx, sel); err != nil {
+		debugEvent(logFields{object: "deployment", namespace: ns, err: err}, "could not list deployments in %v: %v", ns, err)
+	} else {
+		for _, o := range l.Items {
+			if !reapable(o.ObjectMeta, age) {
+				continue
+			}
+			n += reaped(ns, "deployment", o.ObjectMeta, func(ctx context.Context) error {
+				return kapi.AppsV1().Deployments(ns).Delete(ctx, o.Name, opts)
+			})
+		}
+	}
+	cancel()
+	ctx, cancel = apiContext()
+	if l, err := kapi.AppsV1().StatefulSets(ns).List(ctx, sel); err != nil {
+		debugEvent(logFields{object: "statefulset", namespace: ns, err: err}, "could not list statefulsets in %v: %v", ns, err)
+	} else {
+		for _, o := range l.Items {
+			if !reapable(o.ObjectMeta, age) {
+				continue
+			}
+			n += reaped(ns, "statefulset", o.ObjectMeta, func(ctx context.Context) error {
+				return kapi.AppsV1().StatefulSets(ns).Delete(ctx, o.Name, opts)
+			})
+		}
+	}
+	cancel()
+	ctx, cancel = apiContext()
+	if l, err := kapi.BatchV1().Jobs(ns).List(ctx, sel); err != nil {
+		debugEvent(logFields{object: "job", namespace: ns, err: err}, "could not list jobs in %v: %v", ns, err)
+	} else {
+		for _, o := range l.Items {
+			if !reapable(o.ObjectMeta, age) {
+				continue
+			}
+			n += reaped(ns, "job", o.ObjectMeta, func(ctx context.Context) error {
+				return kapi.BatchV1().Jobs(ns).Delete(ctx, o.Name, opts)
+			})
+		}
+	}
+	cancel()
+	ctx, cancel = apiContext()
+	if l, err := kapi.CoreV1().Pods(ns).List(ctx, sel); err != nil {
+		debugEvent(logFields{object: "pod", namespace: ns, err: err}, "could not list pods in %v: %v", ns, err)
+	} else {
+		for _, o := range l.Items {
+			if !reapable(o.ObjectMeta, age) {
+				continue
+			}
+			n += reaped(ns, "pod", o.ObjectMeta, func(ctx context.Context) error {
+				return kapi.CoreV1().Pods(ns).Delete(ctx, o.Name, opts)
+			})
+		}
+	}
+	cancel()
+	ctx, cancel = apiContext()
+	if l, err := kapi.DiscoveryV1beta1().EndpointSlices(ns).List(ctx, sel); err != nil {
+		debugEvent(logFields{object: "endpointslice", namespace: ns, err: err}, "could not list endpointslices in %v: %v", ns, err)
+	} else {
+		for _, o := range l.Items {
+			if !reapable(o.ObjectMeta, age) {
+				continue
+			}
+			n += reaped(ns, "endpointslice", o.ObjectMeta, func(ctx context.Context) error {
+				return kapi.DiscoveryV1beta1().EndpointSlices(ns).Delete(ctx, o.Name, opts)
+			})
+		}
+	}
+	cancel()
+	ctx, cancel = apiContext()
+	if l, err := kapi.CoreV1().Endpoints(ns).List(ctx, sel); err != nil {
+		debugEvent(logFields{object: "endpoints", namespace: ns, err: err}, "could not list endpoints in %v: %v", ns, err)
+	} else {
+		for _, o := range l.Items {
+			if !reapable(o.ObjectMeta, age) {
+				continue
+			}
+			n += reaped(ns, "endpoints", o.ObjectMeta, func(ctx context.Context) error {
+				return kapi.CoreV1().Endpoints(ns).Delete(ctx, o.Name, opts)
+			})
+		}
+	}
+	cancel()
+	ctx, cancel = apiContext()
+	if l, err := kapi.CoreV1().ConfigMaps(ns).List(ctx, sel); err != nil {
+		debugEvent(logFields{object: "configmap", namespace: ns, err: err}, "could not list configmaps in %v: %v", ns, err)
+	} else {
+		for _, o := range l.Items {
+			if !reapable(o.ObjectMeta, age) {
+				continue
+			}
+			n += reaped(ns, "configmap", o.ObjectMeta, func(ctx context.Context) error {
+				return kapi.CoreV1().ConfigMaps(ns).Delete(ctx, o.Name, opts)
+			})
+		}
+	}
+	cancel()
+	ctx, cancel = apiContext()
+	if l, err := kapi.CoreV1().Secrets(ns).List(ctx, sel); err != nil {
+		debugEvent(logFields{object: "secret", namespace: ns, err: err}, "could not list secrets in %v: %v", ns, err)
+	} else {
+		for _, o := range l.Items {
+			if !reapable(o.ObjectMeta, age) {
+				continue
+			}
+			n += reaped(ns, "secret", o.ObjectMeta, func(ctx context.Context) error {
+				return kapi.CoreV1().Secrets(ns).Delete(ctx, o.Name, opts)
+			})
+		}
+	}
+	cancel()
+	for _, k := range []routeKind{ingressKind, httpRouteKind} {
+		if usesKind(k.object) {
+			n += reapRoutes(kapi, k, ns, sel.LabelSelector, age)
+		}
+	}
+	ctx, cancel = apiContext()
+	if l, err := kapi.CoreV1().Services(ns).List(ctx, sel); err != nil {
+		debugEvent(logFields{object: "service", namespace: ns, err: err}, "could not list services in %v: %v", ns, err)
+	} else {
+		for _, o := range l.Items {
+			if !reapable(o.ObjectMeta, age) {
+				continue
+			}
+			n += reaped(ns, "service", o.ObjectMeta, func(ctx context.Context) error {
+				return kapi.CoreV1().Services(ns).Delete(ctx, o.Name, opts)
+			})
+		}
+	}
+	cancel()
+	return n
+}
+
+// reaped deletes the object of meta of kind with del, and returns 1 if it was
+// deleted, otherwise 0. The events carry the trace id the object was labeled
+// with, as the operation that created it may be long gone.
+func reaped(ns, kind string, meta metav1.ObjectMeta, del func(ctx context.Context) error) int {
+	ctx, cancel := apiContext()
+	err := del(ctx)
+	cancel()
+	f := logFields{action: "cleanup", object: kind, name: meta.Name, namespace: ns, trace: meta.Labels[traceLabel], err: err}
+	if errors.IsNotFound(err) {
+		// e.g. a pod of a deployment already deleted
+		return 0
+	}
+	if err != nil {
+		debugEvent(f, "could not clean up %v %v.%v: %v", kind, meta.Name, ns, err)
+		return 0
+	}
+	debugEvent(f, "cleaned up %v %v.%v", kind, meta.Name, ns)
+	return 1
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when advanced, waking the sleepers and
+// tickers whose time came.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// A fakeWaiter is a sleeper, or a ticker if period is set, of a fakeClock.
+type fakeWaiter struct {
+	until  time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1600000000, 0)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Since(t time.Time) time.Duration { return f.Now().Sub(t) }
+
+// Sleep blocks until the clock is advanced by d.
+func (f *fakeClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	f.mu.Lock()
+	w := &fakeWaiter{until: f.now.Add(d), c: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	f.mu.Unlock()
+	<-w.c
+}
+
+func (f *fakeClock) NewTicker(d time.Duration) Ticker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &fakeWaiter{until: f.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	return &fakeTicker{f, w}
+}
+
+// Advance moves the clock by d, waking the sleepers whose time came and
+// ticking the tickers due, once each like a time.Ticker dropping ticks.
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	var waiting []*fakeWaiter
+	for _, w := range f.waiters {
+		if w.until.After(f.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		select {
+		case w.c <- f.now:
+		default:
+		}
+		if w.period > 0 {
+			for !w.until.After(f.now) {
+				w.until = w.until.Add(w.period)
+			}
+			waiting = append(waiting, w)
+		}
+	}
+	f.waiters = waiting
+}
+
+// sleepers returns how many are blocked in Sleep.
+func (f *fakeClock) sleepers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, w := range f.waiters {
+		if w.period == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// waitSleepers waits until n are blocked in Sleep, returning false, or until
+// done is closed, returning true.
+func (f *fakeClock) waitSleepers(n int, done <-chan struct{}) bool {
+	for {
+		select {
+		case <-done:
+			return true
+		default:
+		}
+		if f.sleepers() >= n {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// runAdvancing runs fn, advancing the clock by step whenever it sleeps, until
+// it returns.
+func (f *fakeClock) runAdvancing(step time.Duration, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	for !f.waitSleepers(1, done) {
+		f.Advance(step)
+	}
+}
+
+// useFakeClock replaces the clock with a fake one for the test t.
+func useFakeClock(t *testing.T) *fakeClock {
+	f := newFakeClock()
+	prev := clock
+	clock = f
+	t.Cleanup(func() { clock = prev })
+	return f
+}
+
+type fakeTicker struct {
+	f *fakeClock
+	w *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.c }
+
+func (t *fakeTicker) Stop() {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	for i, w := range t.f.waiters {
+		if w == t.w {
+			t.f.waiters = append(t.f.waiters[:i], t.f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.Stop()
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	t.w.until, t.w.period = t.f.now.Add(d), d
+	t.f.waiters = append(t.f.waiters, t.w)
+}
+
+func TestFakeClockSleep(t *testing.T) {
+	f := newFakeClock()
+	start := f.Now()
+	woke := make(chan struct{})
+	go func() {
+		f.Sleep(3 * time.Second)
+		close(woke)
+	}()
+	f.waitSleepers(1, nil)
+	f.Advance(2 * time.Second)
+	select {
+	case <-woke:
+		t.Fatal("woke before its time")
+	case <-time.After(10 * time.Millisecond):
+	}
+	f.Advance(time.Second)
+	<-woke
+	if got := f.Since(start); got != 3*time.Second {
+		t.Errorf("Since = %v, want 3s", got)
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := newFakeClock()
+	ticker := f.NewTicker(time.Second)
+	defer ticker.Stop()
+	f.Advance(500 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked early")
+	default:
+	}
+	f.Advance(500 * time.Millisecond)
+	select {
+	case <-ticker.C():
+	default:
+		t.Fatal("did not tick")
+	}
+	// ticks not received are dropped, as with a time.Ticker
+	f.Advance(5 * time.Second)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked twice")
+	default:
+	}
+	ticker.Reset(10 * time.Second)
+	f.Advance(9 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked before the reset period")
+	default:
+	}
+	f.Advance(time.Second)
+	<-ticker.C()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// clusterIPCycle creates a ClusterIP service and verifies it resolves to its
+// cluster ip, then deletes and immediately recreates it under the same name,
+// verifying DNS follows to the cluster ip of the new service. Answers with the
+// address of the deleted service are counted as stale. It then deletes the
+// service and verifies the record is removed.
+func clusterIPCycle(kapi kubernetes.Interface, w *workload, b *batch) bool {
+	// generate unique name
+	rando := namePrefix + RandStringBytes(18)
+	defer startTrace(rando)()
+	defer bindWorkload(rando, w)()
+	ns := pickNamespace()
+	host := serviceHost(ns, rando)
+
+	cleanup := func() { deleteService(kapi, ns, rando) }
+
+	oldIP, ok := createClusterIPService(kapi, ns, rando)
+	if !ok {
+		return failCycle("create", cleanup)
+	}
+
+	if !verifySampled() {
+		if !deleteService(kapi, ns, rando) {
+			return failCycle("delete", nil)
+		}
+		return true
+	}
+
+	// verify via DNS in loop with timeout
+	results := verifyQueries("add", []query{{rtype: "IP", name: host, want: []string{oldIP}}}, true)
+	if !allVerified(results) {
+		return failCycle("verify", cleanup)
+	}
+	b.observe(results[0].elapsed)
+
+	if !deleteService(kapi, ns, rando) {
+		return failCycle("delete", nil)
+	}
+	newIP, ok := createClusterIPService(kapi, ns, rando)
+	if !ok {
+		return failCycle("create", cleanup)
+	}
+
+	// verify DNS follows the new cluster ip, even where it is the same
+	queries := []query{{rtype: "IP", name: host, want: []string{newIP}}}
+	if newIP != oldIP {
+		queries[0].stale = []string{oldIP}
+	}
+	results = verifyQueries("recreate", queries, true)
+	if !allVerified(results) {
+		return failCycle("verify", cleanup)
+	}
+	RecreateDuration.WithLabelValues(strconv.FormatBool(newIP != oldIP)).Observe(results[0].elapsed.Seconds())
+
+	if !deleteService(kapi, ns, rando) {
+		return failCycle("delete", nil)
+	}
+
+	// verify via DNS in loop with timeout
+	verifyQueries("delete", queries, false)
+	return true
+}
+
+// createClusterIPService creates the ClusterIP service name, returning its
+// cluster ip, or false if it could not be created.
+func createClusterIPService(kapi kubernetes.Interface, ns, name string) (string, bool) {
+	var svc *v1.Service
+	err := retryCreate("services", func(ctx context.Context) error {
+		var err error
+		svc, err = createServiceObject(ctx, kapi, newService(ns, name))
+		return err
+	})
+	if err == nil && (svc == nil || svc.Spec.ClusterIP == "") {
+		// created by an attempt that timed out
+		ctx, cancel := apiContext()
+		svc, err = kapi.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+		cancel()
+	}
+	if err != nil {
+		recordCreateRejected("services", err)
+		logEvent(logFields{action: "add", object: "service", name: name, namespace: ns, err: err}, "could not create service %v.%v: %v", name, ns, err)
+		return "", false
+	}
+	recordOperation(ns, "service", "add")
+	return svc.Spec.ClusterIP, true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// connectArgs returns the arguments of the connect image, serving HTTP on the
+// container port.
+func connectArgs() []string {
+	return []string{"netexec", "--http-port=" + strconv.Itoa(containerPort)}
+}
+
+// verifyConnect dials each of ips on the container port, retrying until the
+// jittered timeout, with a TCP connect or an HTTP GET depending on the connect
+// mode. It records the latency of each successful connect, counts the ips not
+// reachable within the timeout by reason, and returns true if all were reached.
+func verifyConnect(ns, name string, ips []string) bool {
+	timeout := jitteredTimeout(query{rtype: "IP", name: name})
+	ok := true
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip, strconv.Itoa(containerPort))
+		start := clock.Now()
+		var err error
+		for {
+			var elapsed time.Duration
+			if elapsed, err = connect(addr); err == nil {
+				ConnectDuration.WithLabelValues(connectMode).Observe(elapsed.Seconds())
+				break
+			}
+			if noRetryVerify || abandoned() || clock.Since(start) >= timeout {
+				break
+			}
+			clock.Sleep(pollInterval)
+		}
+		if err != nil {
+			ConnectFailCount.WithLabelValues(connectMode, connectErrorKind(err)).Inc()
+			logEvent(logFields{action: "connect", object: "pod", name: name, namespace: ns, err: err}, "could not connect to %v of %v.%v: %v", addr, name, ns, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// connect connects once to addr per the connect mode, and returns the time it
+// took.
+func connect(addr string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(opsCtx, connectTimeout)
+	defer cancel()
+	start := clock.Now()
+	if connectMode == "tcp" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return 0, err
+		}
+		conn.Close()
+		return clock.Since(start), nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := connectClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, httpStatusError(resp.StatusCode)
+	}
+	return clock.Since(start), nil
+}
+
+// connectClient sends the HTTP connectivity checks, each on a new connection
+// so that every check dials the pod.
+var connectClient = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+// httpStatusError is the error of an HTTP check answered with a status other
+// than 200.
+type httpStatusError int
+
+func (e httpStatusError) Error() string {
+	return fmt.Sprintf("http status %d", int(e))
+}
+
+// connectErrorKind returns the reason label of a failed connect: refused,
+// timeout, http-status or error.
+func connectErrorKind(err error) string {
+	if _, ok := err.(httpStatusError); ok {
+		return "http-status"
+	}
+	if utilnet.IsConnectionRefused(err) {
+		return "refused"
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return "timeout"
+	}
+	return "error"
+}
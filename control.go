@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync/atomic"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	// paused is 1 while operations are paused from the control endpoints.
+	paused int32
+
+	// currentOps holds the float64 bits of the operations per second currently
+	// started.
+	currentOps uint64
+
+	// rateChanges passes rates set from the control endpoints to the ticker.
+	rateChanges = make(chan float64)
+)
+
+// opsPaused returns true if operations are paused, or the shard is idle.
+func opsPaused() bool {
+	return atomic.LoadInt32(&paused) == 1 || atomic.LoadInt32(&shardIdle) == 1
+}
+
+// setCurrentOps sets the operations per second currently started.
+func setCurrentOps(ops float64) {
+	atomic.StoreUint64(&currentOps, math.Float64bits(ops))
+	CurrentOps.Set(ops)
+}
+
+// controlState is the JSON response of the control state endpoint.
+type controlState struct {
+	Ops      float64 `json:"ops"`
+	Profile  string  `json:"profile"`
+	Paused   bool    `json:"paused"`
+	Inflight int64   `json:"inflight"`
+	Queued   int     `json:"queued"`
+	Done     int64   `json:"done"`
+	Failed   int64   `json:"failed"`
+}
+
+// handleControlState returns the rate, whether paused and the counts of
+// operations in flight, queued, done and failed.
+func handleControlState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	done, failed := opsCounts()
+	state := controlState{
+		Ops:      math.Float64frombits(atomic.LoadUint64(&currentOps)),
+		Profile:  profile,
+		Paused:   opsPaused(),
+		Inflight: atomic.LoadInt64(&inflight),
+		Queued:   len(work),
+		Done:     done,
+		Failed:   failed,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// handleControlRate sets the operations per second to the ops of the JSON
+// body, replacing the load profile.
+func handleControlRate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if workloads != nil || observeSelector != "" || shardGroup != "" {
+		http.Error(w, "rate is not supported with config, observe-selector or shard-group", http.StatusConflict)
+		return
+	}
+	var body struct {
+		Ops float64 `json:"ops"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Ops <= 0 {
+		http.Error(w, "ops must be > 0", http.StatusBadRequest)
+		return
+	}
+	select {
+	case rateChanges <- body.Ops:
+		w.WriteHeader(http.StatusNoContent)
+	case <-opsCtx.Done():
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	}
+}
+
+// handlePause returns a handler pausing or resuming operations. Operations in
+// flight run to completion.
+func handlePause(pause bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var v int32
+		if pause {
+			v = 1
+		}
+		if atomic.SwapInt32(&paused, v) != v {
+			logEvent(logFields{action: "pause"}, "Operations paused: %v", pause)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleCleanup returns a handler running a cleanup pass, deleting the objects
+// older than the object ttl, or if not set, than twice the longest timeout, so
+// that the objects of operations in flight are kept.
+func handleCleanup(kapi kubernetes.Interface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		age := objectTTL
+		if age == 0 {
+			for _, wl := range allWorkloads() {
+				if 2*wl.timeout > age {
+					age = 2 * wl.timeout
+				}
+			}
+		}
+		n := reapOlder(kapi, age)
+		OrphansCleanedCount.Add(float64(n))
+		logEvent(logFields{action: "cleanup"}, "Cleaned up %d objects older than %v", n, age)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Cleaned int `json:"cleaned"`
+		}{n})
+	}
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// cycles are the per-tick operations, selected by object. Each reports add
+// propagation delays to the batch it is part of, if any, and returns false if
+// it ended early because of a failure.
+var cycles = map[string]func(kapi kubernetes.Interface, w *workload, b *batch) bool{
+	"service":       serviceCycle,
+	"pod":           podCycle,
+	"endpointslice": endpointSliceCycle,
+	"endpoints":     endpointSliceCycle,
+	"clusterip":     clusterIPCycle,
+	"job":           jobCycle,
+	"deployment":    deploymentCycle,
+	"externalname":  externalNameCycle,
+	"statefulset":   statefulSetCycle,
+	"configmap":     noiseCycle(configMapKind),
+	"secret":        noiseCycle(secretKind),
+}
+
+// serviceCycle creates a headless service backed by one or more pods, verifies
+// the service appears in DNS, then deletes it all and verifies the record is removed.
+func serviceCycle(kapi kubernetes.Interface, w *workload, b *batch) bool {
+	// generate unique name
+	rando := namePrefix + RandStringBytes(18)
+	defer startTrace(rando)()
+	defer bindWorkload(rando, w)()
+	ns := pickNamespace()
+	host := serviceHost(ns, rando)
+
+	cleanup := func() {
+		deletePods(kapi, ns, rando)
+		deleteService(kapi, ns, rando)
+	}
+
+	expectEndpoints(rando)
+	order := pickCreateOrder()
+	if !createServiceObjects(kapi, ns, rando, order) {
+		return failCycle("create", cleanup)
+	}
+	if chaosRoll() {
+		chaosCollide(kapi, ns, rando)
+	}
+
+	if !verifySampled() {
+		if !deletePods(kapi, ns, rando) || !deleteService(kapi, ns, rando) {
+			return failCycle("delete", nil)
+		}
+		return true
+	}
+
+	queries := []query{{rtype: w.recordType, name: host}}
+	if w.recordType == "SRV" {
+		queries = srvQueries(host)
+	}
+	if verifyAllRecords {
+		queries = recordQueries(kapi, ns, rando, host)
+	}
+	if verifyContent && !wantPodIPs(kapi, ns, rando, queries) {
+		return failCycle("verify", cleanup)
+	}
+
+	// verify via DNS in loop with timeout
+	action := "add"
+	if preVerifyQuery {
+		preQuery(queries)
+		action = "pre-queried"
+	}
+	if readinessGate {
+		openReadinessGates(kapi, ns, rando, queries)
+		action = "ready"
+	}
+	verifyStart := clock.Now()
+	results := verifyQueries(action, queries, true)
+	recordSRVPorts(queries, results)
+	if !allVerified(results) {
+		return failCycle("verify", cleanup)
+	}
+	b.observe(results[0].elapsed)
+	if watchEndpointsAPI {
+		recordDNSAfterEndpoints(rando, verifyStart.Add(results[0].elapsed))
+	}
+	if verifySvcSuffix {
+		// relies on the search path of the resolver, unlike the fully qualified name
+		short := verifyQueries("add-svc", []query{{rtype: "IP", name: rando + "." + ns + ".svc"}}, true)
+		if !allVerified(short) {
+			return failCycle("verify", cleanup)
+		}
+	}
+	CreateOrderDuration.WithLabelValues(order).Observe(results[0].elapsed.Seconds())
+	if recordNodes {
+		nodes := recordPodNodes(kapi, ns, rando)
+		if replicas == 1 {
+			recordNodeValidation(nodes[rando], results[0].elapsed)
+		}
+	}
+	if checkUniqueIPs {
+		defer releaseIPs(rando)
+	}
+	var ips []string
+	for i, q := range queries {
+		if q.rtype == "SRV" || q.rtype == "PTR" {
+			continue
+		}
+		ips = append(ips, results[i].answers...)
+		checkExtraAnswers(kapi, ns, rando, results[i].answers)
+		if checkUniqueIPs {
+			claimIPs(rando, results[i].answers)
+		}
+		if verifyPodZone {
+			checkPodZone(ns, rando, results[i].answers)
+		}
+		if topologyHints {
+			recordZoneAnswers(kapi, ns, rando, results[i].answers)
+		}
+	}
+
+	if connectMode != "" && !verifyConnect(ns, rando, ips) {
+		return failCycle("connect", cleanup)
+	}
+	if verifyServiceUpdate && !verifyUpdate(kapi, ns, rando, host) {
+		return failCycle("verify", cleanup)
+	}
+	if reuseName && !verifyReuse(kapi, ns, rando, host) {
+		return failCycle("verify", cleanup)
+	}
+	if verifyTerminatingPod && !verifyTerminating(kapi, ns, rando, host) {
+		return failCycle("verify", cleanup)
+	}
+
+	// capture the ttl of the record to compare with the delete propagation
+	var ttl time.Duration
+	if measureTTL || ttlExpiry {
+		var err error
+		ttl, err = queryTTL(serviceFQDN(ns, rando))
+		if err != nil {
+			debugEvent(logFields{action: "ttl", object: "service", name: rando, namespace: ns, err: err}, "could not get ttl of %v.%v: %v", rando, ns, err)
+		}
+	}
+	if ttlExpiry && ttl > 0 {
+		checkTTLExpiry(serviceFQDN(ns, rando), ttl)
+	}
+
+	if chaosAbandon() {
+		return true
+	}
+
+	if serviceTeardown {
+		return teardownService(kapi, ns, rando, queries)
+	}
+
+	if !deleteServiceObjects(kapi, ns, rando, pickDeleteOrder()) {
+		return failCycle("delete", nil)
+	}
+	if chaosRoll() {
+		chaosRecreate(kapi, ns, rando)
+	}
+
+	// the service is no longer live once deleted
+	releaseIPs(rando)
+
+	// verify via DNS in loop with timeout
+	results = verifyQueries("delete", queries, false)
+	if measureTTL && ttl > 0 && results[0].verified {
+		recordDeleteTTL(results[0].elapsed, ttl)
+	}
+	return true
+}
+
+// teardownService deletes only the service name, verifies that queries are
+// removed while its pods still run, then deletes the pods. A record still
+// served once its service is gone fails the cycle.
+func teardownService(kapi kubernetes.Interface, ns, name string, queries []query) bool {
+	cleanup := func() { deletePods(kapi, ns, name) }
+	if !deleteService(kapi, ns, name) {
+		return failCycle("delete", cleanup)
+	}
+	if !allVerified(verifyQueries("service-delete", queries, false)) {
+		return failCycle("verify", cleanup)
+	}
+	deletePods(kapi, ns, name)
+	return true
+}
+
+// pickCreateOrder returns the order to create the pods and service of a
+// cycle in, choosing randomly between them in random order, or with chaos,
+// at random the other order.
+func pickCreateOrder() string {
+	switch createOrder {
+	case "pod-first":
+		return chaosOrder("create-order", "pod-first", "service-first")
+	case "service-first":
+		return chaosOrder("create-order", "service-first", "pod-first")
+	}
+	if rand.Intn(2) == 0 {
+		return "pod-first"
+	}
+	return "service-first"
+}
+
+// createServiceObjects creates the pods and headless service name in order,
+// pod-first or service-first, returning false if any could not be created.
+func createServiceObjects(kapi kubernetes.Interface, ns, name, order string) bool {
+	if order == "service-first" {
+		return createService(kapi, ns, name) && createPods(kapi, ns, name)
+	}
+	return createPods(kapi, ns, name) && createService(kapi, ns, name)
+}
+
+// preQuery looks up each of queries once, right after creating their objects,
+// which likely caches a negative answer, then waits the pre-verify delay.
+func preQuery(queries []query) {
+	for _, q := range queries {
+		answers, err := lookup(q)
+		switch {
+		case err == nil && len(answers) > 0:
+			PreQueryCount.WithLabelValues("positive").Inc()
+		case err != nil && strings.Contains(err.Error(), "no such host"):
+			PreQueryCount.WithLabelValues("negative").Inc()
+		default:
+			PreQueryCount.WithLabelValues("error").Inc()
+		}
+	}
+	clock.Sleep(preVerifyDelay)
+}
+
+// serviceFQDN returns the fully qualified name of the service name in the
+// namespace ns.
+func serviceFQDN(ns, name string) string {
+	return name + "." + ns + ".svc." + clusterDomain + "."
+}
+
+// serviceHost returns the name to verify the service name by: its fully
+// qualified name, so that the search path of the resolver does not matter,
+// unless mimicking a pod, whose search path then applies to the short name.
+func serviceHost(ns, name string) string {
+	if mimicPodRef != "" {
+		return name + "." + ns
+	}
+	return serviceFQDN(ns, name)
+}
+
+// checkExtraAnswers counts and logs answered addresses of the service name
+// that are not addresses of its pods, e.g. left over from a prior object.
+func checkExtraAnswers(kapi kubernetes.Interface, ns, name string, ips []string) {
+	ctx, cancel := apiContext()
+	defer cancel()
+	pods, err := kapi.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: "app=" + name})
+	if err != nil {
+		debugEvent(logFields{object: "pod", name: name, namespace: ns, err: err}, "could not list pods of %v.%v: %v", name, ns, err)
+		return
+	}
+	expected := make(map[string]bool)
+	for _, p := range pods.Items {
+		expected[p.Status.PodIP] = true
+		for _, ip := range p.Status.PodIPs {
+			expected[ip.IP] = true
+		}
+	}
+	var extra []string
+	for _, ip := range ips {
+		if !expected[ip] {
+			extra = append(extra, ip)
+		}
+	}
+	if len(extra) > 0 {
+		ExtraAnswerCount.Add(float64(len(extra)))
+		logEvent(logFields{action: "add", object: "service", name: name, namespace: ns}, "unexpected answers for %v.%v: %v", name, ns, extra)
+	}
+}
+
+// checkPodZone verifies that the pod record of each answered address of the
+// service name resolves to that same address, counting disagreements between
+// the service and pod zones.
+func checkPodZone(ns, name string, ips []string) {
+	for _, ip := range ips {
+		host := podHost(ns, ip)
+		answers, err := lookup(query{rtype: "IP", name: host})
+		agree := false
+		for _, a := range answers {
+			if a == ip {
+				agree = true
+			}
+		}
+		if !agree {
+			PodZoneDisagreementCount.Inc()
+			logEvent(logFields{action: "add", object: "service", name: name, namespace: ns, err: err}, "pod record %v does not agree with service %v.%v endpoint %v: %v %v", host, name, ns, ip, answers, err)
+		}
+	}
+}
+
+// srvQueries returns an SRV query of host for each named port.
+func srvQueries(host string) []query {
+	var queries []query
+	for _, p := range namedPorts() {
+		queries = append(queries, query{rtype: "SRV", name: host, port: p.name})
+	}
+	return queries
+}
+
+// recordSRVPorts counts the verification results of the SRV queries of each
+// port name.
+func recordSRVPorts(queries []query, results []verification) {
+	for i, q := range queries {
+		if q.rtype != "SRV" {
+			continue
+		}
+		result := "verified"
+		if !results[i].verified {
+			result = "failed"
+		}
+		SRVPortValidationCount.WithLabelValues(q.port, result).Inc()
+	}
+}
+
+// recordQueries returns queries for each record type applicable to the
+// service name: SRV for each named port, A and AAAA for the address families of its pods, and PTR
+// for the address of each of its endpoints.
+func recordQueries(kapi kubernetes.Interface, ns, name, host string) []query {
+	queries := srvQueries(host)
+	ips, err := servicePodIPs(kapi, ns, name)
+	if err != nil {
+		logEvent(logFields{object: "pod", name: name, namespace: ns, err: err}, "could not get ips of pods of %v.%v: %v", name, ns, err)
+		return queries
+	}
+	v4, v6 := false, false
+	for _, ip := range ips {
+		if net.ParseIP(ip).To4() != nil {
+			v4 = true
+		} else {
+			v6 = true
+		}
+		queries = append(queries, query{rtype: "PTR", name: ip, timeout: workloadOf(name).timeout})
+	}
+	if v4 {
+		queries = append(queries, query{rtype: "A", name: host})
+	}
+	if v6 {
+		queries = append(queries, query{rtype: "AAAA", name: host})
+	}
+	return queries
+}
+
+// podCycle creates and deletes pods without a service, optionally verifying
+// the pod A record of each.
+func podCycle(kapi kubernetes.Interface, w *workload, b *batch) bool {
+	// generate unique name
+	rando := namePrefix + RandStringBytes(18)
+	defer startTrace(rando)()
+	defer bindWorkload(rando, w)()
+	ns := pickNamespace()
+
+	cleanup := func() { deletePods(kapi, ns, rando) }
+
+	if !createPods(kapi, ns, rando) {
+		return failCycle("create", cleanup)
+	}
+
+	var queries []query
+	if verifyPodRecord && verifySampled() {
+		for i := 0; i < replicas; i++ {
+			ips, err := waitPodIPs(kapi, ns, podName(rando, i))
+			if err != nil {
+				logEvent(logFields{object: "pod", name: podName(rando, i), namespace: ns, err: err}, "could not get ip of pod %v.%v: %v", podName(rando, i), ns, err)
+				recordValidation("add", ns, "IP", false, 0)
+				return failCycle("verify", cleanup)
+			}
+			queries = append(queries, query{rtype: "IP", name: podHost(ns, ips[0]), timeout: w.timeout})
+		}
+		results := verifyQueries("add", queries, true)
+		if !allVerified(results) {
+			return failCycle("verify", cleanup)
+		}
+		for _, r := range results {
+			b.observe(r.elapsed)
+		}
+		if recordNodes {
+			nodes := recordPodNodes(kapi, ns, rando)
+			for i, r := range results {
+				recordNodeValidation(nodes[podName(rando, i)], r.elapsed)
+			}
+		}
+	}
+
+	if !deletePods(kapi, ns, rando) {
+		return failCycle("delete", nil)
+	}
+
+	// pod records are only removed when the DNS server verifies that the pod exists
+	verifyQueries("delete", queries, false)
+	return true
+}
+
+// failCycle ends a cycle that failed in phase, running cleanup unless the
+// failure policy is to abort, and returns false. Objects left behind by an
+// aborted cycle are removed on exit. The failed cycle is counted by phase; the
+// operation it belongs to, possibly of several cycles, is counted as failed
+// once it is done.
+func failCycle(phase string, cleanup func()) bool {
+	CycleFailCount.WithLabelValues(phase).Inc()
+	if phase == "verify" {
+		recordVerifyStreak(false)
+	}
+	if onFailure == "cleanup" && cleanup != nil {
+		cleanup()
+	}
+	return false
+}
+
+// allVerified returns true if each of results was verified.
+func allVerified(results []verification) bool {
+	for _, r := range results {
+		if !r.verified {
+			return false
+		}
+	}
+	return true
+}
+
+// createPods creates the pods selected by the service name, or applies them in
+// server-side apply mode, returning false if any could not be created.
+func createPods(kapi kubernetes.Interface, ns, name string) bool {
+	ok := true
+	for i := 0; i < replicas; i++ {
+		pod := newPod(ns, name, i)
+		span := startSpan(name, "pod.create", attribute.String("k8s.namespace.name", ns), attribute.String("k8s.pod.name", pod.Name))
+		err := retryCreate("pods", func(ctx context.Context) error {
+			if serverSideApply {
+				pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+				return applyObject(ctx, kapi, ns, "pods", pod.Name, pod)
+			}
+			_, err := kapi.CoreV1().Pods(ns).Create(ctx, pod, metav1.CreateOptions{})
+			return err
+		})
+		endSpan(span, err)
+		if err != nil {
+			recordCreateRejected("pods", err)
+			logEvent(logFields{action: "add", object: "pod", name: podName(name, i), namespace: ns, err: err}, "could not create pod %v.%v: %v", podName(name, i), ns, err)
+			ok = false
+		} else {
+			recordOperation(ns, "pod", "add")
+			debugEvent(logFields{action: "add", object: "pod", name: podName(name, i), namespace: ns}, "created pod %v.%v", podName(name, i), ns)
+		}
+	}
+	return ok
+}
+
+// createService creates the headless service name, or applies it in
+// server-side apply mode, returning false if it could not be created.
+func createService(kapi kubernetes.Interface, ns, name string) bool {
+	svc := newService(ns, name)
+	span := startSpan(name, "service.create", attribute.String("k8s.namespace.name", ns), attribute.String("kubernoisy.service", name))
+	err := retryCreate("services", func(ctx context.Context) error {
+		if serverSideApply {
+			svc.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Service"}
+			return applyObject(ctx, kapi, ns, "services", name, svc)
+		}
+		_, err := createServiceObject(ctx, kapi, svc)
+		return err
+	})
+	endSpan(span, err)
+	if err != nil {
+		recordCreateRejected("services", err)
+		logEvent(logFields{action: "add", object: "service", name: name, namespace: ns, err: err}, "could not create service %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "service", "add")
+	debugEvent(logFields{action: "add", object: "service", name: name, namespace: ns}, "created service %v.%v", name, ns)
+	return true
+}
+
+// deletePods deletes the pods selected by the service name, returning false
+// if any could not be deleted.
+func deletePods(kapi kubernetes.Interface, ns, name string) bool {
+	ok := true
+	for i := 0; i < replicas; i++ {
+		span := startSpan(name, "pod.delete", attribute.String("k8s.namespace.name", ns), attribute.String("k8s.pod.name", podName(name, i)))
+		err := retryDelete("pods", func(ctx context.Context) error {
+			return kapi.CoreV1().Pods(ns).Delete(ctx, podName(name, i), metav1.DeleteOptions{})
+		})
+		if verifyTerminatingPod && errors.IsNotFound(err) {
+			// deleted already when verifying termination
+			span.End()
+			continue
+		}
+		endSpan(span, err)
+		if err != nil {
+			debugEvent(logFields{action: "delete", object: "pod", name: podName(name, i), namespace: ns, err: err}, "could not delete pod %v.%v: %v", podName(name, i), ns, err)
+			ok = false
+		} else {
+			recordOperation(ns, "pod", "delete")
+			debugEvent(logFields{action: "delete", object: "pod", name: podName(name, i), namespace: ns}, "deleted pod %v.%v", podName(name, i), ns)
+		}
+	}
+	return ok
+}
+
+// deleteService deletes the headless service name, returning false if it
+// could not be deleted.
+func deleteService(kapi kubernetes.Interface, ns, name string) bool {
+	forgetEndpoints(name)
+	span := startSpan(name, "service.delete", attribute.String("k8s.namespace.name", ns), attribute.String("kubernoisy.service", name))
+	err := retryDelete("services", func(ctx context.Context) error {
+		return kapi.CoreV1().Services(ns).Delete(ctx, name, metav1.DeleteOptions{})
+	})
+	endSpan(span, err)
+	if err != nil {
+		debugEvent(logFields{action: "delete", object: "service", name: name, namespace: ns, err: err}, "could not delete service %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "service", "delete")
+	debugEvent(logFields{action: "delete", object: "service", name: name, namespace: ns}, "deleted service %v.%v", name, ns)
+	return true
+}
+
+// wantPodIPs sets the addresses of the pods of the service name as the wanted
+// answers of the address queries, of the family of each, so that only the
+// actual pod addresses verify them. It returns false if the pods were not
+// assigned addresses.
+func wantPodIPs(kapi kubernetes.Interface, ns, name string, queries []query) bool {
+	ips, err := servicePodIPs(kapi, ns, name)
+	if err != nil {
+		logEvent(logFields{action: "add", object: "pod", name: name, namespace: ns, err: err}, "could not get ips of pods of %v.%v: %v", name, ns, err)
+		return false
+	}
+	for i, q := range queries {
+		if q.rtype != "IP" && q.rtype != "A" && q.rtype != "AAAA" {
+			continue
+		}
+		queries[i].want = []string{}
+		for _, ip := range ips {
+			v4 := net.ParseIP(ip).To4() != nil
+			if q.rtype == "IP" || (q.rtype == "A") == v4 {
+				queries[i].want = append(queries[i].want, ip)
+			}
+		}
+	}
+	return true
+}
+
+// servicePodIPs returns the addresses of the pods of the service name.
+func servicePodIPs(kapi kubernetes.Interface, ns, name string) ([]string, error) {
+	var ips []string
+	for i := 0; i < replicas; i++ {
+		podIPs, err := waitPodIPs(kapi, ns, podName(name, i))
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, podIPs...)
+	}
+	return ips, nil
+}
+
+// waitPodIPs polls the pod until it is assigned IPs, up to the timeout of its
+// workload or until abandoned on shutdown.
+func waitPodIPs(kapi kubernetes.Interface, ns, name string) ([]string, error) {
+	for start := clock.Now(); clock.Since(start) < workloadOf(name).timeout && !abandoned(); clock.Sleep(time.Second) {
+		ctx, cancel := apiContext()
+		pod, err := kapi.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if len(pod.Status.PodIPs) > 0 {
+			ips := make([]string, len(pod.Status.PodIPs))
+			for i, ip := range pod.Status.PodIPs {
+				ips[i] = ip.IP
+			}
+			return ips, nil
+		}
+		if pod.Status.PodIP != "" {
+			return []string{pod.Status.PodIP}, nil
+		}
+		if pod.Status.Phase == v1.PodFailed {
+			return nil, fmt.Errorf("pod failed")
+		}
+	}
+	return nil, fmt.Errorf("timed out")
+}
+
+// podHost returns the name of the pod A record for ip in the namespace ns.
+func podHost(ns, ip string) string {
+	dashed := strings.NewReplacer(".", "-", ":", "-").Replace(ip)
+	return dashed + "." + ns + ".pod." + clusterDomain + "."
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTeardownService(t *testing.T) {
+	tests := []struct {
+		name      string
+		neverGone bool
+		want      bool
+		podLeft   bool
+	}{
+		{name: "record removed", want: true},
+		{name: "record still served", neverGone: true, podLeft: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeClock(t)
+			r := &fakeResolver{addr: "10.0.0.1", appear: f.Now()}
+			if !tt.neverGone {
+				r.gone = f.Now().Add(2 * time.Second)
+			}
+			useFakeResolver(t, r)
+			prevReplicas, prevOnFailure := replicas, onFailure
+			replicas, onFailure = 1, "abort"
+			defer func() { replicas, onFailure = prevReplicas, prevOnFailure }()
+
+			ns, name := "ns", "kubernoisy-teardown"
+			kapi := fake.NewSimpleClientset(
+				&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns}},
+				&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns}},
+			)
+			queries := []query{{rtype: "IP", name: serviceHost(ns, name), timeout: 5 * time.Second}}
+
+			var ok bool
+			f.runAdvancing(pollInterval, func() { ok = teardownService(kapi, ns, name, queries) })
+			if ok != tt.want {
+				t.Errorf("teardownService = %v, want %v", ok, tt.want)
+			}
+			if _, err := kapi.CoreV1().Services(ns).Get(context.Background(), name, metav1.GetOptions{}); err == nil {
+				t.Error("service was not deleted")
+			}
+			_, err := kapi.CoreV1().Pods(ns).Get(context.Background(), name, metav1.GetOptions{})
+			if podLeft := err == nil; podLeft != tt.podLeft {
+				t.Errorf("pod left = %v, want %v", podLeft, tt.podLeft)
+			}
+		})
+	}
+}
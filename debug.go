@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/kubernetes"
+)
+
+// verifyLimiter rate limits one-off verifications from the debug endpoint.
+var verifyLimiter = rate.NewLimiter(1, 5)
+
+// verifyResult is the JSON response of the debug verify endpoint.
+type verifyResult struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Answers  []string `json:"answers"`
+	Error    string   `json:"error,omitempty"`
+	Duration float64  `json:"duration_seconds"`
+}
+
+// handleVerify resolves the name query parameter once with the configured
+// resolver, for record type given by the optional type parameter.
+func handleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !verifyLimiter.Allow() {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+	q := query{rtype: r.URL.Query().Get("type"), name: r.URL.Query().Get("name")}
+	if q.name == "" {
+		http.Error(w, "missing name", http.StatusBadRequest)
+		return
+	}
+	if q.rtype == "" {
+		q.rtype = "IP"
+	}
+	if !recordTypes[q.rtype] {
+		http.Error(w, "unknown type", http.StatusBadRequest)
+		return
+	}
+
+	start := clock.Now()
+	answers, err := lookup(q)
+	res := verifyResult{Name: q.name, Type: q.rtype, Answers: answers, Duration: clock.Since(start).Seconds()}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// newDebugServer returns the server of the debug and control endpoints.
+func newDebugServer(kapi kubernetes.Interface) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/verify", handleVerify)
+	mux.HandleFunc("/reset", handleReset)
+	mux.HandleFunc("/control/state", handleControlState)
+	mux.HandleFunc("/control/rate", handleControlRate)
+	mux.HandleFunc("/control/pause", handlePause(true))
+	mux.HandleFunc("/control/resume", handlePause(false))
+	mux.HandleFunc("/control/cleanup", handleCleanup(kapi))
+	return &http.Server{
+		Addr:              debugAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		WriteTimeout:      httpWriteTimeout,
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// deploymentCycle creates a Deployment of replicas pods behind a headless
+// service and verifies the service appears in DNS. If scaling up, it then adds
+// replicas to the Deployment and records the time for each added endpoint to
+// appear in DNS. Finally it deletes it all and verifies the record is removed.
+func deploymentCycle(kapi kubernetes.Interface, w *workload, b *batch) bool {
+	// generate unique name
+	rando := namePrefix + RandStringBytes(18)
+	defer startTrace(rando)()
+	defer bindWorkload(rando, w)()
+	ns := pickNamespace()
+	host := serviceHost(ns, rando)
+
+	cleanup := func() {
+		deleteDeployment(kapi, ns, rando)
+		deleteService(kapi, ns, rando)
+	}
+
+	if !createDeployment(kapi, ns, rando) || !createService(kapi, ns, rando) {
+		return failCycle("create", cleanup)
+	}
+
+	queries := []query{{rtype: "IP", name: host}}
+	if verifySampled() {
+		// verify via DNS in loop with timeout
+		results := verifyQueries("add", queries, true)
+		if !allVerified(results) {
+			return failCycle("verify", cleanup)
+		}
+		b.observe(results[0].elapsed)
+
+		if scaleUp > 0 {
+			start := clock.Now()
+			if err := scaleDeployment(kapi, ns, rando, replicas+scaleUp); err != nil {
+				logEvent(logFields{action: "scale", object: "deployment", name: rando, namespace: ns, err: err}, "could not scale deployment %v.%v: %v", rando, ns, err)
+				return failCycle("scale", cleanup)
+			}
+			verified, elapsed := verifyScaleUp(host, results[0].answers, replicas+scaleUp, start)
+			recordValidation("scale-up", ns, "IP", verified, elapsed)
+			if !verified {
+				return failCycle("verify", cleanup)
+			}
+			for i := 0; i < scaleChurn; i++ {
+				// back down, and up again
+				if phase := churnScale(kapi, ns, rando, host, replicas, "scale-down"); phase != "" {
+					return failCycle(phase, cleanup)
+				}
+				if phase := churnScale(kapi, ns, rando, host, replicas+scaleUp, "scale-up"); phase != "" {
+					return failCycle(phase, cleanup)
+				}
+			}
+		}
+	} else {
+		queries = nil
+	}
+
+	if !deleteDeployment(kapi, ns, rando) || !deleteService(kapi, ns, rando) {
+		return failCycle("delete", nil)
+	}
+
+	// verify via DNS in loop with timeout
+	verifyQueries("delete", queries, false)
+	return true
+}
+
+// verifyScaleUp polls DNS until host resolves to target addresses, up to the
+// jittered timeout, recording the time from start for each address not in
+// initial to appear. It returns the time for all of them to appear.
+func verifyScaleUp(host string, initial []string, target int, start time.Time) (bool, time.Duration) {
+	seen := make(map[string]bool)
+	for _, ip := range initial {
+		seen[ip] = true
+	}
+	timeout := jitteredTimeout(query{name: host})
+	for clock.Since(start) < timeout {
+		answers, _ := lookup(query{rtype: "IP", name: host})
+		elapsed := clock.Since(start)
+		for _, ip := range answers {
+			if !seen[ip] {
+				seen[ip] = true
+				ScaleUpEndpointDuration.Observe(elapsed.Seconds())
+			}
+		}
+		if len(answers) >= target {
+			return true, elapsed
+		}
+		clock.Sleep(pollInterval)
+	}
+	debugEvent(logFields{action: "scale", object: "deployment", trace: traceOf(host)}, "%v resolved to %d of %d addresses after scaling up", host, len(seen), target)
+	return false, clock.Since(start)
+}
+
+// churnScale scales the Deployment name to n replicas and verifies host
+// resolves to as many addresses as the Deployment has ready replicas, n, up to
+// the jittered timeout, recording the time under action. It returns the phase
+// that failed, if any.
+func churnScale(kapi kubernetes.Interface, ns, name, host string, n int, action string) string {
+	start := clock.Now()
+	if err := scaleDeployment(kapi, ns, name, n); err != nil {
+		logEvent(logFields{action: "scale", object: "deployment", name: name, namespace: ns, err: err}, "could not scale deployment %v.%v: %v", name, ns, err)
+		return "scale"
+	}
+	var answers []string
+	ready := int32(-1)
+	timeout := jitteredTimeout(query{name: host})
+	for clock.Since(start) < timeout && !abandoned() {
+		answers, _ = lookup(query{rtype: "IP", name: host})
+		if len(answers) == n {
+			ctx, cancel := apiContext()
+			d, err := kapi.AppsV1().Deployments(ns).Get(ctx, name, metav1.GetOptions{})
+			cancel()
+			if err == nil && d.Status.ReadyReplicas == int32(n) {
+				recordValidation(action, ns, "IP", true, clock.Since(start))
+				return ""
+			}
+			if err == nil {
+				ready = d.Status.ReadyReplicas
+			}
+		}
+		clock.Sleep(pollInterval)
+	}
+	debugEvent(logFields{action: action, object: "deployment", name: name, namespace: ns}, "%v resolved to %d addresses after scaling to %d, with %d ready replicas", host, len(answers), n, ready)
+	recordValidation(action, ns, "IP", false, clock.Since(start))
+	return "verify"
+}
+
+// newDeployment returns a Deployment of replicas pods selected by the service
+// name, with the pod spec of other modes.
+func newDeployment(ns, name string) *appsv1.Deployment {
+	pod := newPod(ns, name, 0)
+	n := int32(replicas)
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    workloadLabels(name, map[string]string{"kubernoisy": "noise"}),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &n,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: pod.Labels},
+				Spec:       pod.Spec,
+			},
+		},
+	}
+}
+
+// createDeployment creates the Deployment name, returning false if it could
+// not be created.
+func createDeployment(kapi kubernetes.Interface, ns, name string) bool {
+	ctx, cancel := apiContext()
+	defer cancel()
+	_, err := kapi.AppsV1().Deployments(ns).Create(ctx, newDeployment(ns, name), metav1.CreateOptions{})
+	if err != nil {
+		logEvent(logFields{action: "add", object: "deployment", name: name, namespace: ns, err: err}, "could not create deployment %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "deployment", "add")
+	return true
+}
+
+// scaleDeployment sets the replicas of the Deployment name.
+func scaleDeployment(kapi kubernetes.Interface, ns, name string, n int) error {
+	patch := fmt.Sprintf(`{"spec":{"replicas":%d}}`, n)
+	ctx, cancel := apiContext()
+	defer cancel()
+	_, err := kapi.AppsV1().Deployments(ns).Patch(ctx, name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	recordOperation(ns, "deployment", "scale")
+	return nil
+}
+
+// deleteDeployment deletes the Deployment name and its pods, returning false
+// if it could not be deleted.
+func deleteDeployment(kapi kubernetes.Interface, ns, name string) bool {
+	propagation := metav1.DeletePropagationBackground
+	ctx, cancel := apiContext()
+	defer cancel()
+	err := kapi.AppsV1().Deployments(ns).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil {
+		debugEvent(logFields{action: "delete", object: "deployment", name: name, namespace: ns, err: err}, "could not delete deployment %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "deployment", "delete")
+	return true
+}
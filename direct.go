@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// directResolver sends queries with a DNS client of its own straight to a
+// server, for exactly the names and record types asked, without the search
+// path or caching of a system resolver.
+type directResolver struct {
+	server   string
+	udp, tcp *dns.Client
+}
+
+// newDirectResolver returns a directResolver querying server (host:port) from
+// the dns source ip if set.
+func newDirectResolver(server string) *directResolver {
+	r := &directResolver{server: server, udp: &dns.Client{}, tcp: &dns.Client{Net: "tcp"}}
+	if dnsSourceIP != nil {
+		r.udp.Dialer = &net.Dialer{LocalAddr: &net.UDPAddr{IP: dnsSourceIP}}
+		r.tcp.Dialer = &net.Dialer{LocalAddr: &net.TCPAddr{IP: dnsSourceIP}}
+	}
+	return r
+}
+
+// LookupIPAddr resolves the A and AAAA records of host.
+func (r *directResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+	for _, t := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		rrs, err := r.query(ctx, host, t)
+		if err != nil && !notFound(err) {
+			return nil, err
+		}
+		for _, rr := range rrs {
+			switch a := rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, net.IPAddr{IP: a.A})
+			case *dns.AAAA:
+				addrs = append(addrs, net.IPAddr{IP: a.AAAA})
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, noSuchHost(host)
+	}
+	return addrs, nil
+}
+
+// LookupHost resolves the A and AAAA records of host as strings.
+func (r *directResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]string, len(addrs))
+	for i, a := range addrs {
+		hosts[i] = a.IP.String()
+	}
+	return hosts, nil
+}
+
+// LookupSRV resolves the SRV records of the service of name.
+func (r *directResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	target := "_" + service + "._" + proto + "." + name
+	rrs, err := r.query(ctx, target, dns.TypeSRV)
+	if err != nil {
+		return "", nil, err
+	}
+	var srvs []*net.SRV
+	for _, rr := range rrs {
+		if s, ok := rr.(*dns.SRV); ok {
+			srvs = append(srvs, &net.SRV{Target: s.Target, Port: s.Port, Priority: s.Priority, Weight: s.Weight})
+		}
+	}
+	return target, srvs, nil
+}
+
+// LookupAddr resolves the PTR records of addr.
+func (r *directResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	name, err := dns.ReverseAddr(addr)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: addr}
+	}
+	rrs, err := r.query(ctx, name, dns.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, rr := range rrs {
+		if p, ok := rr.(*dns.PTR); ok {
+			names = append(names, p.Ptr)
+		}
+	}
+	return names, nil
+}
+
+// query returns the answers of type t for the fully qualified name, retrying
+// over tcp if truncated, or a not found error if there are none.
+func (r *directResolver) query(ctx context.Context, name string, t uint16) ([]dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), t)
+	resp, _, err := r.udp.ExchangeContext(ctx, m, r.server)
+	if err == nil && resp.Truncated {
+		resp, _, err = r.tcp.ExchangeContext(ctx, m, r.server)
+	}
+	if err != nil {
+		ne, ok := err.(net.Error)
+		return nil, &net.DNSError{Err: err.Error(), Name: name, Server: r.server, IsTimeout: ok && ne.Timeout()}
+	}
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return nil, noSuchHost(name)
+	default:
+		return nil, &net.DNSError{Err: "server answered " + dns.RcodeToString[resp.Rcode], Name: name, Server: r.server, IsTemporary: true}
+	}
+	var rrs []dns.RR
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == t {
+			rrs = append(rrs, rr)
+		}
+	}
+	if len(rrs) == 0 {
+		return nil, noSuchHost(name)
+	}
+	return rrs, nil
+}
+
+// noSuchHost returns the error of the go resolver for a name without records.
+func noSuchHost(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
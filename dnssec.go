@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// validateDNSSEC queries the records of rtype (IP for A, or failing that
+// AAAA) of host with DNSSEC requested, and returns an error unless they are
+// signed with a valid signature of a DNSKEY of the signing zone. It checks the
+// signatures only, not a chain of trust to the zone's parent.
+func validateDNSSEC(host, rtype string) error {
+	server, err := ttlServer()
+	if err != nil {
+		return err
+	}
+	qtypes := map[string][]uint16{"IP": {dns.TypeA, dns.TypeAAAA}, "A": {dns.TypeA}, "AAAA": {dns.TypeAAAA}}[rtype]
+	var rrs []dns.RR
+	var sigs []*dns.RRSIG
+	for _, t := range qtypes {
+		if rrs, sigs, err = signedRRset(server, fqdnOf(host), t); err != nil {
+			return err
+		}
+		if len(rrs) > 0 {
+			break
+		}
+	}
+	if len(rrs) == 0 {
+		return fmt.Errorf("no %v records", rtype)
+	}
+	if len(sigs) == 0 {
+		return fmt.Errorf("%v records are not signed", rtype)
+	}
+	keys, _, err := signedRRset(server, sigs[0].SignerName, dns.TypeDNSKEY)
+	if err != nil {
+		return fmt.Errorf("could not get dnskey of %v: %v", sigs[0].SignerName, err)
+	}
+	for _, sig := range sigs {
+		if !sig.ValidityPeriod(time.Now()) {
+			continue
+		}
+		for _, rr := range keys {
+			key := rr.(*dns.DNSKEY)
+			if key.KeyTag() == sig.KeyTag && key.Algorithm == sig.Algorithm && sig.Verify(key, rrs) == nil {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no valid signature of %v records by the %d keys of %v", rtype, len(keys), sigs[0].SignerName)
+}
+
+// signedRRset queries server for the records of type t of name with DNSSEC
+// requested, over TCP if truncated, returning the records and the signatures
+// covering them.
+func signedRRset(server, name string, t uint16) ([]dns.RR, []*dns.RRSIG, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), t)
+	m.SetEdns0(4096, true)
+	c := &dns.Client{Timeout: queryTimeout}
+	r, _, err := c.Exchange(m, server)
+	if err == nil && r.Truncated {
+		c.Net = "tcp"
+		r, _, err = c.Exchange(m, server)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, nil, fmt.Errorf("%v", dns.RcodeToString[r.Rcode])
+	}
+	var rrs []dns.RR
+	var sigs []*dns.RRSIG
+	for _, rr := range r.Answer {
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == t {
+			sigs = append(sigs, sig)
+		} else if rr.Header().Rrtype == t {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs, sigs, nil
+}
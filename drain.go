@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// drainGrace is how long past the drain timeout to wait for operations to
+// clean up their objects once their verifications are abandoned.
+const drainGrace = 5 * time.Second
+
+// opsCtx is cancelled on shutdown, abandoning the lookups of the operations
+// still in flight.
+var opsCtx, cancelOps = context.WithCancel(context.Background())
+
+// abandoned returns true if verifications in flight are to be given up,
+// because the drain timeout passed or operations were cancelled on shutdown.
+func abandoned() bool {
+	return drainExpired() || opsCtx.Err() != nil
+}
+
+// drainStart is when draining started, in unix nanoseconds, or 0 if not draining.
+var drainStart int64
+
+// draining returns true if shutting down with operations in flight being drained.
+func draining() bool {
+	return atomic.LoadInt64(&drainStart) != 0
+}
+
+// drainExpired returns true if draining for longer than the drain timeout, so
+// verifications still in flight are to be abandoned.
+func drainExpired() bool {
+	start := atomic.LoadInt64(&drainStart)
+	return start != 0 && clock.Since(time.Unix(0, start)) >= drainTimeout
+}
+
+// drain lets the operations in flight finish, verifying their objects for up
+// to the drain timeout, and waits for them to be done.
+func drain() {
+	atomic.StoreInt64(&drainStart, clock.Now().UnixNano())
+	logEvent(logFields{action: "drain"}, "Draining %d operations in flight for up to %v", atomic.LoadInt64(&inflight), drainTimeout)
+	if n := waitInflight(drainTimeout + drainGrace); n > 0 {
+		logEvent(logFields{action: "drain"}, "%d operations still in flight after draining", n)
+	}
+}
+
+// waitInflight waits up to d for the operations in flight to be done, and
+// returns the number still in flight.
+func waitInflight(d time.Duration) int64 {
+	deadline := clock.Now().Add(d)
+	for atomic.LoadInt64(&inflight) > 0 && clock.Now().Before(deadline) {
+		clock.Sleep(100 * time.Millisecond)
+	}
+	return atomic.LoadInt64(&inflight)
+}
+
+// recordDrained counts a verification done while draining, as verified or, if
+// cut short by the drain timeout, abandoned. It returns true if abandoned, or
+// cut short by the shutdown, so that it is not recorded as a failure.
+func recordDrained(verified bool) bool {
+	if draining() {
+		if verified {
+			DrainedCount.WithLabelValues("verified").Inc()
+			return false
+		}
+		if drainExpired() {
+			DrainedCount.WithLabelValues("abandoned").Inc()
+			return true
+		}
+	}
+	return !verified && opsCtx.Err() != nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// endpointSliceCycle creates a selectorless headless service and writes its
+// EndpointSlice, or its Endpoints for an endpoints workload, directly with
+// addresses from the endpoint cidr, verifies the service resolves to exactly
+// those addresses, then deletes both and verifies the record is removed. No
+// pods are scheduled, so the expected answers do not depend on assigned pod
+// addresses.
+func endpointSliceCycle(kapi kubernetes.Interface, w *workload, b *batch) bool {
+	// generate unique name
+	rando := namePrefix + RandStringBytes(18)
+	defer startTrace(rando)()
+	defer bindWorkload(rando, w)()
+	ns := pickNamespace()
+	host := serviceHost(ns, rando)
+	ips := allocateEndpointIPs(replicas)
+	create, del := createEndpointSlice, deleteEndpointSlice
+	if w.object == "endpoints" {
+		create, del = createEndpoints, deleteEndpoints
+	}
+
+	cleanup := func() {
+		del(kapi, ns, rando)
+		deleteService(kapi, ns, rando)
+	}
+
+	if !createService(kapi, ns, rando) || !create(kapi, ns, rando, ips) {
+		return failCycle("create", cleanup)
+	}
+
+	if !verifySampled() {
+		if !del(kapi, ns, rando) || !deleteService(kapi, ns, rando) {
+			return failCycle("delete", nil)
+		}
+		return true
+	}
+
+	// verify via DNS in loop with timeout
+	queries := []query{{rtype: "IP", name: host, want: ips}}
+	results := verifyQueries("add", queries, true)
+	if !allVerified(results) {
+		return failCycle("verify", cleanup)
+	}
+	b.observe(results[0].elapsed)
+
+	if !del(kapi, ns, rando) || !deleteService(kapi, ns, rando) {
+		return failCycle("delete", nil)
+	}
+
+	// verify via DNS in loop with timeout
+	verifyQueries("delete", queries, false)
+	return true
+}
+
+// newEndpointSlice returns an EndpointSlice of the service name with a ready
+// endpoint for each of ips.
+func newEndpointSlice(ns, name string, ips []string) *discovery.EndpointSlice {
+	addressType := discovery.AddressTypeIPv4
+	if endpointCIDR.IP.To4() == nil {
+		addressType = discovery.AddressTypeIPv6
+	}
+	ready := true
+	protocol := v1.ProtocolTCP
+	var ports []discovery.EndpointPort
+	for _, p := range namedPorts() {
+		name, port := p.name, int32(p.port)
+		ports = append(ports, discovery.EndpointPort{Name: &name, Port: &port, Protocol: &protocol})
+	}
+	slice := &discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels: workloadLabels(name, map[string]string{
+				"kubernoisy":               "noise",
+				discovery.LabelServiceName: name,
+				discovery.LabelManagedBy:   "kubernoisy",
+			}),
+		},
+		AddressType: addressType,
+		Ports:       ports,
+	}
+	for _, ip := range ips {
+		slice.Endpoints = append(slice.Endpoints, discovery.Endpoint{
+			Addresses:  []string{ip},
+			Conditions: discovery.EndpointConditions{Ready: &ready},
+		})
+	}
+	return slice
+}
+
+// createEndpointSlice creates the EndpointSlice of the service name, returning
+// false if it could not be created.
+func createEndpointSlice(kapi kubernetes.Interface, ns, name string, ips []string) bool {
+	ctx, cancel := apiContext()
+	defer cancel()
+	_, err := kapi.DiscoveryV1beta1().EndpointSlices(ns).Create(ctx, newEndpointSlice(ns, name, ips), metav1.CreateOptions{})
+	if err != nil {
+		logEvent(logFields{action: "add", object: "endpointslice", name: name, namespace: ns, err: err}, "could not create endpointslice %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "endpointslice", "add")
+	return true
+}
+
+// deleteEndpointSlice deletes the EndpointSlice of the service name, returning
+// false if it could not be deleted.
+func deleteEndpointSlice(kapi kubernetes.Interface, ns, name string) bool {
+	ctx, cancel := apiContext()
+	defer cancel()
+	err := kapi.DiscoveryV1beta1().EndpointSlices(ns).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil {
+		debugEvent(logFields{action: "delete", object: "endpointslice", name: name, namespace: ns, err: err}, "could not delete endpointslice %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "endpointslice", "delete")
+	return true
+}
+
+// endpointAlloc is the offset in the endpoint cidr of the next address to allocate.
+var endpointAlloc struct {
+	sync.Mutex
+	next uint64
+}
+
+// endpointCIDRSize returns the number of allocatable addresses in the endpoint
+// cidr, excluding its first and last addresses.
+func endpointCIDRSize() uint64 {
+	ones, bits := endpointCIDR.Mask.Size()
+	if bits-ones >= 63 {
+		return 1 << 62
+	}
+	if bits-ones < 2 {
+		return 0
+	}
+	return 1<<uint(bits-ones) - 2
+}
+
+// allocateEndpointIPs returns the next n sequential addresses of the endpoint
+// cidr, wrapping around to its start once exhausted.
+func allocateEndpointIPs(n int) []string {
+	size := endpointCIDRSize()
+	endpointAlloc.Lock()
+	defer endpointAlloc.Unlock()
+	ips := make([]string, n)
+	for i := range ips {
+		if endpointAlloc.next >= size {
+			endpointAlloc.next = 0
+		}
+		ips[i] = addToIP(endpointCIDR.IP, endpointAlloc.next+1).String()
+		endpointAlloc.next++
+	}
+	return ips
+}
+
+// addToIP returns ip offset by n.
+func addToIP(ip net.IP, n uint64) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	sum := make(net.IP, len(ip))
+	copy(sum, ip)
+	for i := len(sum) - 1; i >= 0 && n > 0; i-- {
+		n += uint64(sum[i])
+		sum[i] = byte(n)
+		n >>= 8
+	}
+	return sum
+}
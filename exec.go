@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// execResolver resolves names by running nslookup inside an existing pod, so
+// that verification reflects that pod's view of DNS rather than kubernoisy's own.
+type execResolver struct {
+	config    *rest.Config
+	kapi      kubernetes.Interface
+	namespace string
+	name      string
+	container string
+	zone      string
+	server    string
+}
+
+// newExecResolver returns an execResolver for the pod ref, given as
+// "namespace/name" or just "name" in the operating namespace.
+func newExecResolver(config *rest.Config, kapi kubernetes.Interface, ref string) (*execResolver, error) {
+	ns, name := namespace, ref
+	if i := strings.Index(ref, "/"); i >= 0 {
+		ns, name = ref[:i], ref[i+1:]
+	}
+	ctx, cancel := apiContext()
+	defer cancel()
+	pod, err := kapi.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get exec pod %v.%v: %v", name, ns, err)
+	}
+	if pod.Status.Phase != v1.PodRunning {
+		return nil, fmt.Errorf("exec pod %v.%v is not running", name, ns)
+	}
+	r := &execResolver{
+		config:    config,
+		kapi:      kapi,
+		namespace: ns,
+		name:      name,
+		container: pod.Spec.Containers[0].Name,
+		zone:      nodeZone(kapi, pod.Spec.NodeName),
+	}
+	if resolverFamily != "" {
+		conf, err := r.exec("cat", "/etc/resolv.conf")
+		if err != nil {
+			return nil, fmt.Errorf("could not read resolv.conf of exec pod %v.%v: %v", name, ns, err)
+		}
+		r.server, err = pickNameserver(parseNameservers(conf), resolverFamily)
+		if err != nil {
+			return nil, fmt.Errorf("exec pod %v.%v: %v", name, ns, err)
+		}
+	}
+	return r, nil
+}
+
+// exec runs cmd in the pod, returning its combined output.
+func (r *execResolver) exec(cmd ...string) (string, error) {
+	req := r.kapi.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(r.namespace).
+		Name(r.name).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: r.container,
+			Command:   cmd,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(r.config, "POST", req.URL())
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	err = executor.Stream(remotecommand.StreamOptions{Stdout: &out, Stderr: &out})
+	return out.String(), err
+}
+
+// nslookup runs nslookup with args in the pod, against the selected nameserver
+// if any. A missing record is reported as a "no such host" error, matching the
+// system resolver.
+func (r *execResolver) nslookup(name string, args ...string) (string, error) {
+	cmd := append([]string{"nslookup"}, args...)
+	cmd = append(cmd, name)
+	if r.server != "" {
+		cmd = append(cmd, r.server)
+	}
+	out, err := r.exec(cmd...)
+	if strings.Contains(out, "NXDOMAIN") || strings.Contains(out, "can't find") || strings.Contains(out, "can't resolve") {
+		return out, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	if err != nil {
+		return out, fmt.Errorf("exec nslookup %v in %v.%v: %v", name, r.name, r.namespace, err)
+	}
+	return out, nil
+}
+
+// LookupIPAddr resolves host to addresses with nslookup in the pod.
+func (r *execResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	out, err := r.nslookup(host)
+	ips := parseNslookupAddrs(out)
+	if len(ips) > 0 {
+		return ips, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, &net.DNSError{Err: "no answer", Name: host}
+}
+
+// LookupHost resolves host to address strings with nslookup in the pod.
+func (r *execResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]string, len(addrs))
+	for i, a := range addrs {
+		hosts[i] = a.String()
+	}
+	return hosts, nil
+}
+
+// LookupSRV resolves the SRV records of _service._proto.name with nslookup in the pod.
+func (r *execResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	target := "_" + service + "._" + proto + "." + name
+	out, err := r.nslookup(target, "-type=srv")
+	var srvs []*net.SRV
+	for _, f := range parseNslookupValues(out, "service =") {
+		// priority weight port target
+		v := strings.Fields(f)
+		if len(v) != 4 {
+			continue
+		}
+		prio, _ := strconv.Atoi(v[0])
+		weight, _ := strconv.Atoi(v[1])
+		port, _ := strconv.Atoi(v[2])
+		srvs = append(srvs, &net.SRV{Target: v[3], Port: uint16(port), Priority: uint16(prio), Weight: uint16(weight)})
+	}
+	if len(srvs) > 0 {
+		return target, srvs, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return "", nil, &net.DNSError{Err: "no answer", Name: target}
+}
+
+// LookupAddr resolves the PTR records of addr with nslookup in the pod.
+func (r *execResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	out, err := r.nslookup(addr)
+	names := parseNslookupValues(out, "name =")
+	if len(names) > 0 {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, &net.DNSError{Err: "no answer", Name: addr}
+}
+
+// parseNslookupAddrs returns the answer addresses in nslookup output. Both the
+// busybox and bind flavors print the server address before the first "Name:"
+// line, and the answers after it.
+func parseNslookupAddrs(out string) []net.IPAddr {
+	var ips []net.IPAddr
+	answers := false
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "Name:") {
+			answers = true
+			continue
+		}
+		if !answers || !strings.HasPrefix(line, "Address") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		for _, f := range strings.Fields(line[i+1:]) {
+			if ip := net.ParseIP(f); ip != nil {
+				ips = append(ips, net.IPAddr{IP: ip})
+			}
+		}
+	}
+	return ips
+}
+
+// parseNslookupValues returns what follows sep on each line of nslookup
+// output, e.g. "name =" for PTR records.
+func parseNslookupValues(out, sep string) []string {
+	var values []string
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, sep); i >= 0 {
+			values = append(values, strings.TrimSpace(line[i+len(sep):]))
+		}
+	}
+	return values
+}
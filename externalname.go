@@ -0,0 +1,62 @@
+package main
+
+import (
+	"k8s.io/client-go/kubernetes"
+)
+
+// externalNameCycle creates an ExternalName service aliasing the external name
+// and verifies it resolves, through the CNAME, to the addresses of the external
+// name, then deletes it and verifies the record is removed.
+func externalNameCycle(kapi kubernetes.Interface, w *workload, b *batch) bool {
+	// generate unique name
+	rando := namePrefix + RandStringBytes(18)
+	defer startTrace(rando)()
+	defer bindWorkload(rando, w)()
+	ns := pickNamespace()
+	host := serviceHost(ns, rando)
+
+	cleanup := func() { deleteService(kapi, ns, rando) }
+
+	if !createService(kapi, ns, rando) {
+		return failCycle("create", cleanup)
+	}
+
+	if !verifySampled() {
+		if !deleteService(kapi, ns, rando) {
+			return failCycle("delete", nil)
+		}
+		return true
+	}
+
+	// the alias resolves to whatever the target does
+	target, err := lookup(query{rtype: "IP", name: externalNameTarget()})
+	if err != nil {
+		logEvent(logFields{action: "add", object: "service", name: rando, namespace: ns, err: err}, "could not resolve external name %v: %v", externalNameTarget(), err)
+		return failCycle("verify", cleanup)
+	}
+
+	// verify via DNS in loop with timeout
+	queries := []query{{rtype: "IP", name: host, want: target}}
+	results := verifyQueries("add", queries, true)
+	if !allVerified(results) {
+		return failCycle("verify", cleanup)
+	}
+	b.observe(results[0].elapsed)
+
+	if !deleteService(kapi, ns, rando) {
+		return failCycle("delete", nil)
+	}
+
+	// verify via DNS in loop with timeout
+	verifyQueries("delete", queries, false)
+	return true
+}
+
+// externalNameTarget returns the name ExternalName services alias: the
+// external name, or the kubernetes service of the cluster domain.
+func externalNameTarget() string {
+	if externalName != "" {
+		return externalName
+	}
+	return "kubernetes.default.svc." + clusterDomain
+}
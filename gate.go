@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// readinessGateCondition is the pod condition gating the readiness of pods
+// created with a readiness gate.
+const readinessGateCondition v1.PodConditionType = "kubernoisy.io/ready"
+
+// openReadinessGates waits for the pods of the service name to be ready but
+// for their readiness gate, verifies that the service has no records while
+// gated, then opens the gates.
+func openReadinessGates(kapi kubernetes.Interface, ns, name string, queries []query) {
+	for i := 0; i < replicas; i++ {
+		if err := waitContainersReady(kapi, ns, podName(name, i)); err != nil {
+			logEvent(logFields{object: "pod", name: podName(name, i), namespace: ns, err: err}, "could not wait for containers of pod %v.%v: %v", podName(name, i), ns, err)
+		}
+	}
+
+	for _, q := range queries {
+		answers, err := lookup(q)
+		if err == nil && len(answers) > 0 {
+			ValidationFailCount.WithLabelValues("gated", q.rtype, familyLabel(), "premature", queryNamespace(q.name), validationMethod()).Inc()
+			logEvent(logFields{action: "gated", name: q.name}, "%v record %v resolved before the readiness gate opened: %v", q.rtype, q.name, answers)
+		}
+	}
+
+	patch := []byte(fmt.Sprintf(`{"status":{"conditions":[{"type":%q,"status":"True"}]}}`, readinessGateCondition))
+	for i := 0; i < replicas; i++ {
+		ctx, cancel := apiContext()
+		_, err := kapi.CoreV1().Pods(ns).Patch(ctx, podName(name, i), types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "status")
+		cancel()
+		if err != nil {
+			logEvent(logFields{object: "pod", name: podName(name, i), namespace: ns, err: err}, "could not open readiness gate of pod %v.%v: %v", podName(name, i), ns, err)
+		}
+	}
+}
+
+// waitContainersReady polls the pod until its containers are ready, up to the
+// timeout of its workload.
+func waitContainersReady(kapi kubernetes.Interface, ns, name string) error {
+	for start := clock.Now(); clock.Since(start) < workloadOf(name).timeout && !abandoned(); clock.Sleep(time.Second) {
+		ctx, cancel := apiContext()
+		pod, err := kapi.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			return err
+		}
+		for _, c := range pod.Status.Conditions {
+			if c.Type == v1.ContainersReady && c.Status == v1.ConditionTrue {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("timed out")
+}
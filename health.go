@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	// looping is set once connected to the API server and the main loop started.
+	looping int32
+	// prepopulating is set while the prepopulated services are created.
+	prepopulating int32
+	// lastSuccess is the time, in unix nanoseconds, of the last operation that
+	// completed successfully.
+	lastSuccess int64
+	// lastTick is the time, in unix nanoseconds, the ops ticker last ticked,
+	// and tickEvery the longest interval it currently ticks at.
+	lastTick  int64
+	tickEvery int64
+	// lastAPIHealthy is the time, in unix nanoseconds, the API server last
+	// answered a health check.
+	lastAPIHealthy int64
+	// opsDue counts the operations due at the ticks not held back, opsLaunched
+	// those of them started, and keepUp holds the float64 bits of the fraction
+	// of the operations due started in the last health interval.
+	opsDue      int64
+	opsLaunched int64
+	keepUp      = math.Float64bits(1)
+	// failStreak counts the consecutive operations that failed verification.
+	failStreak int64
+)
+
+// startedLooping records that the main loop started, having reached the API
+// server.
+func startedLooping() {
+	now := clock.Now().UnixNano()
+	atomic.StoreInt64(&lastAPIHealthy, now)
+	atomic.StoreInt64(&lastTick, now)
+	atomic.StoreInt32(&looping, 1)
+}
+
+// recordSuccess records that an operation completed successfully.
+func recordSuccess() {
+	atomic.StoreInt64(&lastSuccess, clock.Now().UnixNano())
+}
+
+// recordTick records that the ops ticker ticked, whether or not operations
+// were started.
+func recordTick() {
+	atomic.StoreInt64(&lastTick, clock.Now().UnixNano())
+}
+
+// recordAPIHealthy records that the API server answered a health check.
+func recordAPIHealthy() {
+	atomic.StoreInt64(&lastAPIHealthy, clock.Now().UnixNano())
+	APIHealthy.Set(1)
+}
+
+// setTickInterval sets the longest interval the ops tickers currently tick at.
+func setTickInterval(d time.Duration) {
+	atomic.StoreInt64(&tickEvery, int64(d))
+}
+
+// stallWindow returns how long the ops ticker may not tick before it is
+// stalled: the live window, or at low rates, ten tick intervals, past the
+// long delays of Poisson arrivals too.
+func stallWindow() time.Duration {
+	if few := 10 * time.Duration(atomic.LoadInt64(&tickEvery)); few > liveWindow {
+		return few
+	}
+	return liveWindow
+}
+
+// recordVerifyStreak extends the streak of operations failing verification,
+// or ends it if ok.
+func recordVerifyStreak(ok bool) {
+	n := int64(0)
+	if !ok {
+		n = atomic.AddInt64(&failStreak, 1)
+	} else {
+		atomic.StoreInt64(&failStreak, 0)
+	}
+	ValidationFailStreak.Set(float64(n))
+}
+
+// checkHealth checks every interval that the API server answers, and how much
+// of the operations due at the ticks since the last check were started, if
+// any were due.
+func checkHealth(kapi kubernetes.Interface, interval time.Duration) {
+	APIHealthy.Set(1)
+	KeepUpRatio.Set(1)
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	var launched, due int64
+	for range ticker.C() {
+		if _, err := kapi.Discovery().ServerVersion(); err != nil {
+			APIHealthy.Set(0)
+			debugEvent(logFields{err: err}, "API server health check failed: %v", err)
+		} else {
+			recordAPIHealthy()
+		}
+
+		launched, due = updateKeepUp(launched, due)
+	}
+}
+
+// updateKeepUp sets the keep-up fraction to the operations started of those
+// due since the counts launched and due, unless none were due, e.g. between
+// the ticks of a low rate or while operations are held back, and returns the
+// current counts.
+func updateKeepUp(launched, due int64) (int64, int64) {
+	// due before started, so loaded the other way around, so that no more are
+	// started than were due
+	n := atomic.LoadInt64(&opsLaunched)
+	d := atomic.LoadInt64(&opsDue)
+	if d > due {
+		ratio := math.Min(float64(n-launched)/float64(d-due), 1)
+		atomic.StoreUint64(&keepUp, math.Float64bits(ratio))
+		KeepUpRatio.Set(ratio)
+	}
+	return n, d
+}
+
+// healthProblems returns why kubernoisy is wedged: the API server has not
+// answered within the live window, or the ops ticker has not ticked within the
+// stall window.
+func healthProblems() []string {
+	var problems []string
+	if since := clock.Since(time.Unix(0, atomic.LoadInt64(&lastAPIHealthy))); since > liveWindow {
+		problems = append(problems, fmt.Sprintf("API server not answering for %v", since.Truncate(time.Second)))
+	}
+	// observing has no ticker
+	if since := clock.Since(time.Unix(0, atomic.LoadInt64(&lastTick))); observeSelector == "" && since > stallWindow() {
+		problems = append(problems, fmt.Sprintf("ops ticker stalled for %v", since.Truncate(time.Second)))
+	}
+	return problems
+}
+
+// handleHealthz serves 200 while prepopulating, or once the main loop started
+// and while it is not wedged, and 503 otherwise.
+func handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	if atomic.LoadInt32(&prepopulating) == 1 {
+		fmt.Fprintln(w, "prepopulating")
+		return
+	}
+	if atomic.LoadInt32(&looping) == 0 {
+		http.Error(w, "not started", http.StatusServiceUnavailable)
+		return
+	}
+	if problems := healthProblems(); len(problems) > 0 {
+		http.Error(w, strings.Join(problems, "; "), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz serves 200 if an operation completed successfully within the
+// ready window, kubernoisy is not wedged, keeps up with the ready keep-up
+// fraction of the operations due and has not failed verification more than the
+// ready fail streak of times in a row, and 503 otherwise. The body reports
+// each check.
+func handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	last := atomic.LoadInt64(&lastSuccess)
+	if last == 0 {
+		http.Error(w, "no operation completed yet", http.StatusServiceUnavailable)
+		return
+	}
+	problems := healthProblems()
+	if since := clock.Since(time.Unix(0, last)); since > readyWindow {
+		problems = append(problems, fmt.Sprintf("no operation completed in the last %v", since.Truncate(time.Second)))
+	}
+	ratio := math.Float64frombits(atomic.LoadUint64(&keepUp))
+	if ratio < readyKeepUp {
+		problems = append(problems, fmt.Sprintf("started %.2f of the operations due", ratio))
+	}
+	streak := atomic.LoadInt64(&failStreak)
+	if readyFailStreak > 0 && streak >= int64(readyFailStreak) {
+		problems = append(problems, fmt.Sprintf("%d operations in a row failed verification", streak))
+	}
+	if len(problems) > 0 {
+		http.Error(w, strings.Join(problems, "; "), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "ok: started %.2f of the operations due, %d operations in a row failed verification\n", ratio, streak)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHealthProblemsTickerStall(t *testing.T) {
+	f := useFakeClock(t)
+	prevWindow := liveWindow
+	liveWindow = 5 * time.Minute
+	defer func() { liveWindow = prevWindow }()
+
+	tests := []struct {
+		name      string
+		interval  time.Duration
+		sinceTick time.Duration
+		stalled   bool
+	}{
+		{name: "ticked within the live window", interval: time.Second, sinceTick: 4 * time.Minute},
+		{name: "not ticked within the live window", interval: time.Second, sinceTick: 6 * time.Minute, stalled: true},
+		// at 0.001 operations per second, ticks are 1000s apart
+		{name: "low rate between ticks", interval: 1000 * time.Second, sinceTick: 1500 * time.Second},
+		{name: "low rate not ticked for ten intervals", interval: 1000 * time.Second, sinceTick: 10001 * time.Second, stalled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startedLooping()
+			setTickInterval(tt.interval)
+			f.Advance(tt.sinceTick)
+			// the API server answered all along
+			recordAPIHealthy()
+			if problems := healthProblems(); (len(problems) > 0) != tt.stalled {
+				t.Errorf("healthProblems = %v, want stalled %v", problems, tt.stalled)
+			}
+		})
+	}
+}
+
+func TestUpdateKeepUp(t *testing.T) {
+	opsDue, opsLaunched, keepUp = 0, 0, math.Float64bits(1)
+	defer func() { opsDue, opsLaunched, keepUp = 0, 0, math.Float64bits(1) }()
+
+	steps := []struct {
+		name          string
+		due, launched int64
+		ratio         float64
+	}{
+		{name: "all started", due: 10, launched: 10, ratio: 1},
+		{name: "half skipped", due: 10, launched: 5, ratio: 0.5},
+		// e.g. between the ticks of a batched or low rate, or while held back
+		{name: "none due", ratio: 0.5},
+		{name: "caught up", due: 3, launched: 3, ratio: 1},
+	}
+	var launched, due int64
+	for _, s := range steps {
+		opsDue += s.due
+		opsLaunched += s.launched
+		launched, due = updateKeepUp(launched, due)
+		if got := math.Float64frombits(keepUp); got != s.ratio {
+			t.Errorf("%v: keep-up %v, want %v", s.name, got, s.ratio)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// opsDone counts the operations done, and opsFailed those of them that
+	// failed, once per operation however many cycles it ran.
+	opsDone   int64
+	opsFailed int64
+	inflight  int64
+
+	recentMu sync.Mutex
+	recent   = newReservoir(1000)
+)
+
+// work queues the operations for the workers when the operations in flight
+// are limited.
+var work chan func() bool
+
+// startWorkers starts n workers running the operations launched, and lets up
+// to queue operations wait for a free worker.
+func startWorkers(n, queue int) {
+	work = make(chan func() bool, queue)
+	for i := 0; i < n; i++ {
+		go func() {
+			for f := range work {
+				QueuedOperations.Dec()
+				if draining() || opsCtx.Err() != nil {
+					// queued before shutdown, no longer to be started
+					atomic.AddInt64(&inflight, -1)
+					OperationsSkipped.Inc()
+					continue
+				}
+				track(f)
+			}
+		}()
+	}
+}
+
+// launch starts the operation f in the background, or with limited operations
+// in flight queues it for a worker, unless every worker is busy and the queue
+// is full, in which case it is counted as skipped and dropped. Queued operations count as
+// in flight, so that draining waits for them.
+func launch(f func() bool) {
+	if !takeOp() {
+		return
+	}
+	atomic.AddInt64(&inflight, 1)
+	if work == nil {
+		atomic.AddInt64(&opsLaunched, 1)
+		go track(f)
+		return
+	}
+	select {
+	case work <- f:
+		atomic.AddInt64(&opsLaunched, 1)
+		QueuedOperations.Inc()
+	default:
+		atomic.AddInt64(&inflight, -1)
+		OperationsSkipped.Inc()
+		DroppedOperations.Inc()
+	}
+}
+
+// opsLeft is the remaining total operations budget, and opsBudgetDone is
+// closed once it is spent and the last operation is done.
+var (
+	opsLeft       int64
+	opsBudgetDone = make(chan struct{})
+	budgetSpent   int32
+)
+
+// takeOp takes an operation from the total operations budget, returning false
+// if it is spent. Every operation is allowed without a budget. Operations
+// skipped for the max inflight still spend it, so that the run ends on
+// schedule. Once spent, it waits for the operations in flight to be done.
+func takeOp() bool {
+	if totalOps == 0 {
+		return true
+	}
+	if atomic.AddInt64(&opsLeft, -1) < 0 {
+		atomic.AddInt64(&opsLeft, 1)
+		if atomic.CompareAndSwapInt32(&budgetSpent, 0, 1) {
+			go func() {
+				for atomic.LoadInt64(&inflight) > 0 {
+					clock.Sleep(100 * time.Millisecond)
+				}
+				close(opsBudgetDone)
+			}()
+		}
+		return false
+	}
+	return true
+}
+
+// track runs the operation f, counting it while in flight and once done, and
+// recording its outcome for the error backoff and readiness. The caller counts it in flight.
+func track(f func() bool) {
+	defer atomic.AddInt64(&inflight, -1)
+	InflightOperations.Inc()
+	defer InflightOperations.Dec()
+	ok := f()
+	errorBackoff.record(ok)
+	if ok {
+		recordSuccess()
+		recordVerifyStreak(true)
+	}
+	atomic.AddInt64(&opsDone, 1)
+	if !ok {
+		atomic.AddInt64(&opsFailed, 1)
+	}
+}
+
+// opsCounts returns the operations done and how many of them failed. Failures
+// are counted after the operation is done, and loaded first, so that they never
+// exceed the operations done.
+func opsCounts() (done, failed int64) {
+	failed = atomic.LoadInt64(&opsFailed)
+	return atomic.LoadInt64(&opsDone), failed
+}
+
+// sampleRecent adds a validation latency to the samples of the current heartbeat interval.
+func sampleRecent(d time.Duration) {
+	recentMu.Lock()
+	recent.Add(d.Seconds())
+	recentMu.Unlock()
+}
+
+// heartbeat logs a summary of progress at every interval, so that operators
+// can see the tool is alive without a metrics stack.
+func heartbeat(interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		recentMu.Lock()
+		r := recent
+		recent = newReservoir(1000)
+		recentMu.Unlock()
+
+		q, n := r.Quantiles(0.99)
+		done, failed := opsCounts()
+		logEvent(logFields{action: "heartbeat"}, "Heartbeat: %d operations done, %d failed, %d in flight, %d validations in the last %v with p99 %.3fs",
+			done, failed, atomic.LoadInt64(&inflight), n, interval, q[0])
+	}
+}
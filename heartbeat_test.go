@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTrackCountsFailuresPerOperation(t *testing.T) {
+	opsDone, opsFailed = 0, 0
+	defer func() { opsDone, opsFailed = 0, 0 }()
+
+	// e.g. a batch, or an operation with additional kinds, failing several cycles
+	track(func() bool {
+		failCycle("create", nil)
+		failCycle("verify", nil)
+		return false
+	})
+	track(func() bool { return true })
+
+	if done, failed := opsCounts(); done != 2 || failed != 1 {
+		t.Errorf("opsCounts = %d done, %d failed; want 2 done, 1 failed", done, failed)
+	}
+	if rep := newReport(); rep.FailureRate != 0.5 {
+		t.Errorf("FailureRate = %v, want 0.5", rep.FailureRate)
+	}
+}
+
+func TestLaunchDropsWhenSaturated(t *testing.T) {
+	prevWork := work
+	// no worker takes from the queue, and it has no room, so the pool is saturated
+	work = make(chan func() bool)
+	defer func() { work = prevWork }()
+
+	prevSkipped, prevDropped := testutil.ToFloat64(OperationsSkipped), testutil.ToFloat64(DroppedOperations)
+	launch(func() bool { return true })
+	if got := testutil.ToFloat64(DroppedOperations) - prevDropped; got != 1 {
+		t.Errorf("dropped operations = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(OperationsSkipped) - prevSkipped; got != 1 {
+		t.Errorf("skipped operations = %v, want 1", got)
+	}
+}
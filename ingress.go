@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// A routeKind is a kind of object routing external traffic to a service,
+// written as JSON through the REST API, since the client has no typed
+// clients for the current versions.
+type routeKind struct {
+	object   string
+	group    string
+	version  string
+	resource string
+}
+
+var (
+	ingressKind   = routeKind{object: "ingress", group: "networking.k8s.io", version: "v1", resource: "ingresses"}
+	httpRouteKind = routeKind{object: "httproute", group: "gateway.networking.k8s.io", version: "v1", resource: "httproutes"}
+)
+
+// path returns the REST path of the objects of k in ns, or of the object name
+// if not empty.
+func (k routeKind) path(ns, name string) string {
+	p := fmt.Sprintf("/apis/%v/%v/namespaces/%v/%v", k.group, k.version, ns, k.resource)
+	if name != "" {
+		p += "/" + name
+	}
+	return p
+}
+
+// routeHost returns the host name routed to the service name.
+func routeHost(name string) string {
+	return name + "." + ingressDomain
+}
+
+// newRoute returns the object of k routing the host of name to the service
+// name on the container port.
+func newRoute(k routeKind, ns, name string) map[string]interface{} {
+	meta := map[string]interface{}{
+		"name":      name,
+		"namespace": ns,
+		"labels":    workloadLabels(name, map[string]string{"kubernoisy": "noise"}),
+	}
+	if k == ingressKind {
+		spec := map[string]interface{}{
+			"rules": []interface{}{map[string]interface{}{
+				"host": routeHost(name),
+				"http": map[string]interface{}{"paths": []interface{}{map[string]interface{}{
+					"path":     "/",
+					"pathType": "Prefix",
+					"backend": map[string]interface{}{"service": map[string]interface{}{
+						"name": name,
+						"port": map[string]interface{}{"number": containerPort},
+					}},
+				}}},
+			}},
+		}
+		if ingressClass != "" {
+			spec["ingressClassName"] = ingressClass
+		}
+		return map[string]interface{}{"apiVersion": k.group + "/" + k.version, "kind": "Ingress", "metadata": meta, "spec": spec}
+	}
+	gwNS, gwName := ns, gateway
+	if i := strings.Index(gateway, "/"); i >= 0 {
+		gwNS, gwName = gateway[:i], gateway[i+1:]
+	}
+	spec := map[string]interface{}{
+		"parentRefs": []interface{}{map[string]interface{}{"name": gwName, "namespace": gwNS}},
+		"hostnames":  []interface{}{routeHost(name)},
+		"rules": []interface{}{map[string]interface{}{
+			"backendRefs": []interface{}{map[string]interface{}{"name": name, "port": containerPort}},
+		}},
+	}
+	return map[string]interface{}{"apiVersion": k.group + "/" + k.version, "kind": "HTTPRoute", "metadata": meta, "spec": spec}
+}
+
+// createRoute creates the object of k for the service name, returning false
+// if it could not be created.
+func createRoute(kapi kubernetes.Interface, k routeKind, ns, name string) bool {
+	data, err := json.Marshal(newRoute(k, ns, name))
+	if err == nil {
+		ctx, cancel := apiContext()
+		err = kapi.CoreV1().RESTClient().Post().AbsPath(k.path(ns, "")).SetHeader("Content-Type", "application/json").Body(data).Do(ctx).Error()
+		cancel()
+	}
+	if err != nil {
+		recordCreateRejected(k.resource, err)
+		logEvent(logFields{action: "add", object: k.object, name: name, namespace: ns, err: err}, "could not create %v %v.%v: %v", k.object, name, ns, err)
+		return false
+	}
+	recordOperation(ns, k.object, "add")
+	return true
+}
+
+// deleteRoute deletes the object of k name, returning false if it could not
+// be deleted.
+func deleteRoute(kapi kubernetes.Interface, k routeKind, ns, name string) bool {
+	ctx, cancel := apiContext()
+	defer cancel()
+	err := kapi.CoreV1().RESTClient().Delete().AbsPath(k.path(ns, name)).Do(ctx).Error()
+	if err != nil {
+		debugEvent(logFields{action: "delete", object: k.object, name: name, namespace: ns, err: err}, "could not delete %v %v.%v: %v", k.object, name, ns, err)
+		return false
+	}
+	recordOperation(ns, k.object, "delete")
+	return true
+}
+
+// routeStatus is the part of the status of an Ingress or HTTPRoute telling
+// whether a controller took it.
+type routeStatus struct {
+	Status struct {
+		LoadBalancer struct {
+			Ingress []interface{} `json:"ingress"`
+		} `json:"loadBalancer"`
+		Parents []struct {
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+		} `json:"parents"`
+	} `json:"status"`
+}
+
+// admitted returns true if a controller took the object of s: an Ingress
+// with a load balancer address, or an HTTPRoute accepted by a parent.
+func (s routeStatus) admitted() bool {
+	if len(s.Status.LoadBalancer.Ingress) > 0 {
+		return true
+	}
+	for _, p := range s.Status.Parents {
+		for _, c := range p.Conditions {
+			if c.Type == "Accepted" && c.Status == "True" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// waitRouteAdmitted polls the object of k name until admitted by a
+// controller, up to the timeout of its workload, and returns the time it took.
+func waitRouteAdmitted(kapi kubernetes.Interface, k routeKind, ns, name string) (time.Duration, error) {
+	timeout := workloadOf(name).timeout
+	start := clock.Now()
+	for clock.Since(start) < timeout && !abandoned() {
+		ctx, cancel := apiContext()
+		data, err := kapi.CoreV1().RESTClient().Get().AbsPath(k.path(ns, name)).Do(ctx).Raw()
+		cancel()
+		if err != nil && !errors.IsNotFound(err) {
+			return 0, err
+		}
+		var s routeStatus
+		if err == nil && json.Unmarshal(data, &s) == nil && s.admitted() {
+			return clock.Since(start), nil
+		}
+		clock.Sleep(pollInterval)
+	}
+	return 0, fmt.Errorf("not admitted after %v", timeout)
+}
+
+// routeCycle returns the cycle of the kind k: it creates a headless service
+// and an object of k routing a host to it, verifies a controller reacts to it,
+// by its status or by the host resolving, e.g. through external-dns, then
+// deletes them and with DNS, verifies the host is removed.
+func routeCycle(k routeKind) func(kapi kubernetes.Interface, w *workload, b *batch) bool {
+	return func(kapi kubernetes.Interface, w *workload, b *batch) bool {
+		// generate unique name
+		rando := namePrefix + RandStringBytes(18)
+		defer startTrace(rando)()
+		defer bindWorkload(rando, w)()
+		ns := pickNamespace()
+
+		cleanup := func() {
+			deleteRoute(kapi, k, ns, rando)
+			deleteService(kapi, ns, rando)
+		}
+
+		if !createService(kapi, ns, rando) || !createRoute(kapi, k, ns, rando) {
+			return failCycle("create", cleanup)
+		}
+
+		queries := []query{{rtype: "IP", name: routeHost(rando) + "."}}
+		verify := verifySampled() && ingressVerify != "none"
+		if verify && ingressVerify == "status" {
+			elapsed, err := waitRouteAdmitted(kapi, k, ns, rando)
+			if err != nil {
+				logEvent(logFields{action: "add", object: k.object, name: rando, namespace: ns, err: err}, "%v %v.%v was not admitted: %v", k.object, rando, ns, err)
+				RouteAdmitFailCount.WithLabelValues(k.object).Inc()
+				return failCycle("verify", cleanup)
+			}
+			RouteAdmitDuration.WithLabelValues(k.object).Observe(elapsed.Seconds())
+			b.observe(elapsed)
+		}
+		if verify && ingressVerify == "dns" {
+			results := verifyQueries("add-"+k.object, queries, true)
+			if !allVerified(results) {
+				return failCycle("verify", cleanup)
+			}
+			b.observe(results[0].elapsed)
+		}
+
+		if !deleteRoute(kapi, k, ns, rando) || !deleteService(kapi, ns, rando) {
+			return failCycle("delete", nil)
+		}
+		if verify && ingressVerify == "dns" {
+			verifyQueries("delete-"+k.object, queries, false)
+		}
+		return true
+	}
+}
+
+// reapRoutes deletes the objects of k in ns of the label selector sel that are
+// reapable by age, and returns how many were deleted.
+func reapRoutes(kapi kubernetes.Interface, k routeKind, ns, sel string, age time.Duration) int {
+	ctx, cancel := apiContext()
+	data, err := kapi.CoreV1().RESTClient().Get().AbsPath(k.path(ns, "")).Param("labelSelector", sel).Do(ctx).Raw()
+	cancel()
+	if err != nil {
+		debugEvent(logFields{object: k.object, namespace: ns, err: err}, "could not list %v in %v: %v", k.resource, ns, err)
+		return 0
+	}
+	var l struct {
+		Items []struct {
+			Metadata metav1.ObjectMeta `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &l); err != nil {
+		debugEvent(logFields{object: k.object, namespace: ns, err: err}, "could not decode %v in %v: %v", k.resource, ns, err)
+		return 0
+	}
+	n := 0
+	for _, o := range l.Items {
+		if !reapable(o.Metadata, age) {
+			continue
+		}
+		n += reaped(ns, k.object, o.Metadata, func(ctx context.Context) error {
+			return kapi.CoreV1().RESTClient().Delete().AbsPath(k.path(ns, o.Metadata.Name)).Do(ctx).Error()
+		})
+	}
+	return n
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ipFamilyPolicies are the valid values of the ip family policy.
+var ipFamilyPolicies = map[string]bool{"SingleStack": true, "PreferDualStack": true, "RequireDualStack": true}
+
+// createServiceObject creates svc, with the ip family policy if set, which
+// the typed client of this API version cannot send.
+func createServiceObject(ctx context.Context, kapi kubernetes.Interface, svc *v1.Service) (*v1.Service, error) {
+	if ipFamilyPolicy == "" {
+		return kapi.CoreV1().Services(svc.Namespace).Create(ctx, svc, metav1.CreateOptions{})
+	}
+	svc.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Service"}
+	data, err := json.Marshal(svc)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	obj["spec"].(map[string]interface{})["ipFamilyPolicy"] = ipFamilyPolicy
+	if data, err = json.Marshal(obj); err != nil {
+		return nil, err
+	}
+	created := &v1.Service{}
+	err = kapi.CoreV1().RESTClient().Post().
+		Namespace(svc.Namespace).
+		Resource("services").
+		SetHeader("Content-Type", "application/json").
+		Body(data).
+		Do(ctx).
+		Into(created)
+	return created, err
+}
+
+// recordCreateRejected counts err if the API server rejected creating an
+// object of resource, e.g. as invalid or forbidden by admission, rather than
+// failing to process it.
+func recordCreateRejected(resource string, err error) {
+	if errors.IsInvalid(err) || errors.IsForbidden(err) || errors.IsBadRequest(err) {
+		CreateRejectedCount.WithLabelValues(resource, string(errors.ReasonForError(err))).Inc()
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// jobCycle creates a Job whose pod runs for the job duration then completes,
+// behind a headless service. It verifies the service record appears while the
+// pod runs, and once the pod has completed, that the record is removed, then
+// deletes the job and service.
+func jobCycle(kapi kubernetes.Interface, w *workload, b *batch) bool {
+	// generate unique name
+	rando := namePrefix + RandStringBytes(18)
+	defer startTrace(rando)()
+	defer bindWorkload(rando, w)()
+	ns := pickNamespace()
+	host := serviceHost(ns, rando)
+
+	cleanup := func() {
+		deleteJob(kapi, ns, rando)
+		deleteService(kapi, ns, rando)
+	}
+
+	if !createJob(kapi, ns, rando) || !createService(kapi, ns, rando) {
+		return failCycle("create", cleanup)
+	}
+
+	if verifySampled() {
+		// verify via DNS in loop with timeout
+		queries := []query{{rtype: "IP", name: host}}
+		results := verifyQueries("add", queries, true)
+		if !allVerified(results) {
+			return failCycle("verify", cleanup)
+		}
+		b.observe(results[0].elapsed)
+
+		// completed pods are removed from the endpoints of the service
+		if err := waitJobPodsSucceeded(kapi, ns, rando); err != nil {
+			logEvent(logFields{object: "job", name: rando, namespace: ns, err: err}, "could not wait for pods of job %v.%v to complete: %v", rando, ns, err)
+			return failCycle("verify", cleanup)
+		}
+		verifyQueries("complete", queries, false)
+	}
+
+	if !deleteJob(kapi, ns, rando) || !deleteService(kapi, ns, rando) {
+		return failCycle("delete", nil)
+	}
+	return true
+}
+
+// newJob returns a Job running replicas pods selected by the service name,
+// each exiting successfully after the job duration.
+func newJob(ns, name string) *batchv1.Job {
+	completions := int32(replicas)
+	backoffLimit := int32(0)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    workloadLabels(name, map[string]string{"kubernoisy": "noise"}),
+		},
+		Spec: batchv1.JobSpec{
+			Parallelism:  &completions,
+			Completions:  &completions,
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: workloadLabels(name, map[string]string{"app": name, "kubernoisy": "noise"}),
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Containers: []v1.Container{{
+						Name:    name,
+						Image:   "busybox:1.31",
+						Command: []string{"sleep", strconv.Itoa(int(jobDuration / time.Second))},
+						Ports:   containerPorts(),
+					}},
+					ImagePullSecrets: imagePullSecrets(),
+				},
+			},
+		},
+	}
+}
+
+// createJob creates the Job name, returning false if it could not be created.
+func createJob(kapi kubernetes.Interface, ns, name string) bool {
+	ctx, cancel := apiContext()
+	defer cancel()
+	_, err := kapi.BatchV1().Jobs(ns).Create(ctx, newJob(ns, name), metav1.CreateOptions{})
+	if err != nil {
+		logEvent(logFields{action: "add", object: "job", name: name, namespace: ns, err: err}, "could not create job %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "job", "add")
+	return true
+}
+
+// deleteJob deletes the Job name and its pods, returning false if it could
+// not be deleted.
+func deleteJob(kapi kubernetes.Interface, ns, name string) bool {
+	propagation := metav1.DeletePropagationBackground
+	ctx, cancel := apiContext()
+	defer cancel()
+	err := kapi.BatchV1().Jobs(ns).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil {
+		debugEvent(logFields{action: "delete", object: "job", name: name, namespace: ns, err: err}, "could not delete job %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "job", "delete")
+	return true
+}
+
+// waitJobPodsSucceeded polls the pods of the Job name until they have all
+// succeeded, up to the timeout of their workload.
+func waitJobPodsSucceeded(kapi kubernetes.Interface, ns, name string) error {
+	for start := clock.Now(); clock.Since(start) < workloadOf(name).timeout && !abandoned(); clock.Sleep(time.Second) {
+		ctx, cancel := apiContext()
+		pods, err := kapi.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: "app=" + name})
+		cancel()
+		if err != nil {
+			return err
+		}
+		succeeded := 0
+		for _, p := range pods.Items {
+			switch p.Status.Phase {
+			case v1.PodSucceeded:
+				succeeded++
+			case v1.PodFailed:
+				return fmt.Errorf("pod %v failed", p.Name)
+			}
+		}
+		if succeeded >= replicas {
+			return nil
+		}
+	}
+	return fmt.Errorf("timed out")
+}
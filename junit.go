@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// junitSuite, junitCase and junitFailure are the JUnit XML elements of a report.
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnitReport writes the exit summary to file as a JUnit test suite: a
+// test case for all operations, and one for the validations of each action,
+// each failing if its failure rate exceeds the junit failure threshold.
+func writeJUnitReport(file string) error {
+	suite := junitSuite{Name: "kubernoisy"}
+	done, failed := opsCounts()
+	suite.add(junitRateCase("operations", done-failed, failed, ""))
+
+	samplesMu.Lock()
+	for _, action := range summaryActions() {
+		var verified int64
+		out := ""
+		if r, ok := samples[action]; ok {
+			var q []float64
+			q, verified = r.Quantiles(summaryQuantiles...)
+			out = strings.TrimPrefix(formatQuantiles(q), ", ")
+		}
+		suite.add(junitRateCase("validation "+action, verified, failures[action], out))
+	}
+	samplesMu.Unlock()
+
+	b, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append([]byte(xml.Header), append(b, '\n')...), 0644)
+}
+
+// junitRateCase returns a test case of ok successes, never negative, and
+// failed failures, failing if the failure rate exceeds the junit failure
+// threshold.
+func junitRateCase(name string, ok, failed int64, out string) junitCase {
+	if ok < 0 {
+		ok = 0
+	}
+	c := junitCase{Name: name, ClassName: "kubernoisy"}
+	total := ok + failed
+	c.SystemOut = fmt.Sprintf("%d of %d succeeded", ok, total)
+	if out != "" {
+		c.SystemOut += ", " + out
+	}
+	if total > 0 {
+		if rate := float64(failed) / float64(total); rate > junitFailureThreshold {
+			c.Failure = &junitFailure{Message: fmt.Sprintf("failure rate %.4f exceeds %.4f", rate, junitFailureThreshold)}
+		}
+	}
+	return c
+}
+
+// add adds the test case c to the suite.
+func (s *junitSuite) add(c junitCase) {
+	s.Cases = append(s.Cases, c)
+	s.Tests++
+	if c.Failure != nil {
+		s.Failures++
+	}
+}
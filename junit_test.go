@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestJUnitRateCase(t *testing.T) {
+	prev := junitFailureThreshold
+	junitFailureThreshold = 0.1
+	defer func() { junitFailureThreshold = prev }()
+
+	tests := []struct {
+		name     string
+		ok, fail int64
+		out      string
+		failing  bool
+	}{
+		{name: "all passed", ok: 10, out: "10 of 10 succeeded"},
+		{name: "below threshold", ok: 19, fail: 1, out: "19 of 20 succeeded"},
+		{name: "above threshold", ok: 8, fail: 2, out: "8 of 10 succeeded", failing: true},
+		{name: "negative passed", ok: -1, fail: 3, out: "0 of 3 succeeded", failing: true},
+		{name: "none", out: "0 of 0 succeeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := junitRateCase("operations", tt.ok, tt.fail, "")
+			if c.SystemOut != tt.out || (c.Failure != nil) != tt.failing {
+				t.Errorf("junitRateCase = %q, failing %v; want %q, failing %v", c.SystemOut, c.Failure != nil, tt.out, tt.failing)
+			}
+		})
+	}
+}
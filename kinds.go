@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// kindCycles are the cycles of the additional object kinds, selected by the
+// kinds flag, run each tick alongside the cycle of the object.
+var kindCycles = map[string]func(kapi kubernetes.Interface, w *workload, b *batch) bool{
+	"endpoints": endpointsCycle,
+	"configmap": noiseCycle(configMapKind),
+	"secret":    noiseCycle(secretKind),
+	"ingress":   routeCycle(ingressKind),
+	"httproute": routeCycle(httpRouteKind),
+}
+
+// usesKind returns true if kind is one of the additional object kinds.
+func usesKind(kind string) bool {
+	kinds, _ := parseKinds(kinds)
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKinds parses a comma separated list of distinct additional object kinds.
+func parseKinds(s string) ([]string, error) {
+	var kinds []string
+	if s == "" {
+		return kinds, nil
+	}
+	seen := make(map[string]bool)
+	for _, k := range strings.Split(s, ",") {
+		k = strings.TrimSpace(k)
+		if _, ok := kindCycles[k]; !ok {
+			return nil, fmt.Errorf("unknown kind %q", k)
+		}
+		if seen[k] {
+			return nil, fmt.Errorf("kind %q is listed twice", k)
+		}
+		seen[k] = true
+		kinds = append(kinds, k)
+	}
+	return kinds, nil
+}
+
+// withKinds returns a cycle running cycle and the cycle of each of kinds
+// concurrently, which succeeds only if all of them succeed.
+func withKinds(cycle func(kubernetes.Interface, *workload, *batch) bool, kinds []string) func(kubernetes.Interface, *workload, *batch) bool {
+	if len(kinds) == 0 {
+		return cycle
+	}
+	all := []func(kubernetes.Interface, *workload, *batch) bool{cycle}
+	for _, k := range kinds {
+		all = append(all, kindCycles[k])
+	}
+	return func(kapi kubernetes.Interface, w *workload, b *batch) bool {
+		var wg sync.WaitGroup
+		ok := make([]bool, len(all))
+		for i, c := range all {
+			wg.Add(1)
+			go func(i int, c func(kubernetes.Interface, *workload, *batch) bool) {
+				defer wg.Done()
+				ok[i] = c(kapi, w, b)
+			}(i, c)
+		}
+		wg.Wait()
+		for _, o := range ok {
+			if !o {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// endpointsCycle creates pods and a selectorless headless service, writes its
+// Endpoints directly with the addresses of the pods, verifies the service
+// resolves to exactly those addresses, then deletes it all and verifies the
+// record is removed. This tests the records of manually managed endpoints.
+func endpointsCycle(kapi kubernetes.Interface, w *workload, b *batch) bool {
+	// generate unique name
+	rando := namePrefix + RandStringBytes(18)
+	defer startTrace(rando)()
+	defer bindWorkload(rando, w)()
+	ns := pickNamespace()
+	host := serviceHost(ns, rando)
+
+	cleanup := func() {
+		deletePods(kapi, ns, rando)
+		deleteEndpoints(kapi, ns, rando)
+		deleteService(kapi, ns, rando)
+	}
+
+	if !createPods(kapi, ns, rando) {
+		return failCycle("create", cleanup)
+	}
+	ips, err := servicePodIPs(kapi, ns, rando)
+	if err != nil {
+		logEvent(logFields{action: "add", object: "pod", name: rando, namespace: ns, err: err}, "could not get pod ips of %v.%v: %v", rando, ns, err)
+		return failCycle("create", cleanup)
+	}
+	if !createSelectorlessService(kapi, ns, rando) || !createEndpoints(kapi, ns, rando, ips) {
+		return failCycle("create", cleanup)
+	}
+
+	if !verifySampled() {
+		if !deletePods(kapi, ns, rando) || !deleteEndpoints(kapi, ns, rando) || !deleteService(kapi, ns, rando) {
+			return failCycle("delete", nil)
+		}
+		return true
+	}
+
+	// verify via DNS in loop with timeout
+	queries := []query{{rtype: "IP", name: host, want: ips}}
+	results := verifyQueries("add-endpoints", queries, true)
+	if !allVerified(results) {
+		return failCycle("verify", cleanup)
+	}
+	b.observe(results[0].elapsed)
+
+	if !deletePods(kapi, ns, rando) || !deleteEndpoints(kapi, ns, rando) || !deleteService(kapi, ns, rando) {
+		return failCycle("delete", nil)
+	}
+
+	// verify via DNS in loop with timeout
+	verifyQueries("delete-endpoints", queries, false)
+	return true
+}
+
+// createSelectorlessService creates the headless service name without a
+// selector, so that its endpoints are left to be written directly, returning
+// false if it could not be created.
+func createSelectorlessService(kapi kubernetes.Interface, ns, name string) bool {
+	svc := newService(ns, name)
+	svc.Spec.ClusterIP = v1.ClusterIPNone
+	svc.Spec.Selector = nil
+	ctx, cancel := apiContext()
+	defer cancel()
+	if _, err := createServiceObject(ctx, kapi, svc); err != nil {
+		recordCreateRejected("services", err)
+		logEvent(logFields{action: "add", object: "service", name: name, namespace: ns, err: err}, "could not create service %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "service", "add")
+	debugEvent(logFields{action: "add", object: "service", name: name, namespace: ns}, "created service %v.%v", name, ns)
+	return true
+}
+
+// newEndpoints returns the Endpoints of the service name with a ready address
+// for each of ips.
+func newEndpoints(ns, name string, ips []string) *v1.Endpoints {
+	subset := v1.EndpointSubset{}
+	for _, ip := range ips {
+		subset.Addresses = append(subset.Addresses, v1.EndpointAddress{IP: ip})
+	}
+	for _, p := range namedPorts() {
+		subset.Ports = append(subset.Ports, v1.EndpointPort{Name: p.name, Port: int32(p.port), Protocol: v1.ProtocolTCP})
+	}
+	return &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    workloadLabels(name, map[string]string{"kubernoisy": "noise"}),
+		},
+		Subsets: []v1.EndpointSubset{subset},
+	}
+}
+
+// createEndpoints creates the Endpoints of the service name, returning false
+// if it could not be created.
+func createEndpoints(kapi kubernetes.Interface, ns, name string, ips []string) bool {
+	ctx, cancel := apiContext()
+	defer cancel()
+	_, err := kapi.CoreV1().Endpoints(ns).Create(ctx, newEndpoints(ns, name, ips), metav1.CreateOptions{})
+	if err != nil {
+		logEvent(logFields{action: "add", object: "endpoints", name: name, namespace: ns, err: err}, "could not create endpoints %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "endpoints", "add")
+	return true
+}
+
+// deleteEndpoints deletes the Endpoints of the service name, returning false
+// if it could not be deleted.
+func deleteEndpoints(kapi kubernetes.Interface, ns, name string) bool {
+	ctx, cancel := apiContext()
+	defer cancel()
+	err := kapi.CoreV1().Endpoints(ns).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil {
+		debugEvent(logFields{action: "delete", object: "endpoints", name: name, namespace: ns, err: err}, "could not delete endpoints %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "endpoints", "delete")
+	return true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// checkListenAddrs returns an error if any of the listen addresses, by flag
+// name, is invalid or collides with another: the same port on the same host,
+// or with either listening on all interfaces. Empty addresses are disabled.
+func checkListenAddrs(addrs map[string]string) error {
+	var names []string
+	for name := range addrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type listen struct{ name, host, port string }
+	var listens []listen
+	for _, name := range names {
+		if addrs[name] == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(addrs[name])
+		if err != nil {
+			return fmt.Errorf("invalid %v address %q: %v", name, addrs[name], err)
+		}
+		for _, l := range listens {
+			// port 0 picks a free port
+			if port != "0" && l.port == port && (l.host == host || allInterfaces(l.host) || allInterfaces(host)) {
+				return fmt.Errorf("%v address %q collides with %v address %q", name, addrs[name], l.name, addrs[l.name])
+			}
+		}
+		listens = append(listens, listen{name, host, port})
+	}
+	return nil
+}
+
+// allInterfaces returns true if listening on host listens on all interfaces.
+func allInterfaces(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "" || ip != nil && ip.IsUnspecified()
+}
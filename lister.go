@@ -0,0 +1,40 @@
+package main
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// backgroundList lists the pods and services in each of the namespaces at
+// every interval, adding read load on the API server as controllers do.
+func backgroundList(kapi kubernetes.Interface, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		for _, ns := range activeNamespaces() {
+			start := clock.Now()
+			ctx, cancel := apiContext()
+			_, err := kapi.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+			cancel()
+			recordList(ns, "pod", start, err)
+
+			start = clock.Now()
+			ctx, cancel = apiContext()
+			_, err = kapi.CoreV1().Services(ns).List(ctx, metav1.ListOptions{})
+			cancel()
+			recordList(ns, "service", start, err)
+		}
+	}
+}
+
+// recordList records a list of object in the namespace ns started at start.
+func recordList(ns, object string, start time.Time, err error) {
+	if err != nil {
+		debugEvent(logFields{action: "list", object: object, namespace: ns, err: err}, "could not list %vs in %v: %v", object, ns, err)
+		return
+	}
+	recordOperation(ns, object, "list")
+	ListDuration.WithLabelValues(object).Observe(clock.Since(start).Seconds())
+}
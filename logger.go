@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logFields are the fields of a logged event, omitted if empty.
+type logFields struct {
+	action    string
+	object    string
+	name      string
+	namespace string
+	elapsed   time.Duration
+	err       error
+	// trace is the trace id of the event, if not that of the cycle of name
+	trace string
+}
+
+// logger writes the events, in the text log format until setupLogFormat.
+var logger = newLogger(textEncoder(), zapcore.InfoLevel)
+
+func newLogger(enc zapcore.Encoder, level zapcore.Level) *zap.Logger {
+	return zap.New(zapcore.NewCore(enc, zapcore.Lock(os.Stderr), level))
+}
+
+// textEncoder encodes an event as its time, level and message alone.
+func textEncoder() zapcore.Encoder {
+	return zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
+		TimeKey:     "time",
+		LevelKey:    "level",
+		MessageKey:  "msg",
+		EncodeTime:  zapcore.TimeEncoderOfLayout("2006/01/02 15:04:05"),
+		EncodeLevel: zapcore.CapitalLevelEncoder,
+	})
+}
+
+// jsonEncoder encodes an event as a json object with its fields, stamped in
+// UTC.
+func jsonEncoder() zapcore.Encoder {
+	return zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		TimeKey:    "time",
+		LevelKey:   "level",
+		MessageKey: "msg",
+		LineEnding: zapcore.DefaultLineEnding,
+		EncodeTime: func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+			enc.AppendString(t.UTC().Format(time.RFC3339Nano))
+		},
+		EncodeLevel: zapcore.LowercaseLevelEncoder,
+	})
+}
+
+// setupLogFormat directs the events to the log format, with debug events if
+// verbose, and the lines of the standard logger, the fatal errors, as error
+// events.
+func setupLogFormat() error {
+	var enc zapcore.Encoder
+	switch logFormat {
+	case "text":
+		enc = textEncoder()
+	case "json":
+		enc = jsonEncoder()
+	default:
+		return fmt.Errorf("unknown log-format %q", logFormat)
+	}
+	level := zapcore.InfoLevel
+	if verbose {
+		level = zapcore.DebugLevel
+	}
+	logger = newLogger(enc, level)
+	if _, err := zap.RedirectStdLogAt(logger, zapcore.ErrorLevel); err != nil {
+		return err
+	}
+	return nil
+}
+
+// logEvent logs an event with fields f, at the error level if f has an error.
+func logEvent(f logFields, format string, v ...interface{}) {
+	level := zapcore.InfoLevel
+	if f.err != nil {
+		level = zapcore.ErrorLevel
+	}
+	writeEvent(level, f, format, v...)
+}
+
+// debugEvent logs an event with fields f at the debug level, if verbose.
+func debugEvent(f logFields, format string, v ...interface{}) {
+	writeEvent(zapcore.DebugLevel, f, format, v...)
+}
+
+// writeEvent writes the event with fields f if its level is enabled: the
+// message alone in the text log format, prefixed by the trace id of its cycle
+// if any.
+func writeEvent(level zapcore.Level, f logFields, format string, v ...interface{}) {
+	if !logger.Core().Enabled(level) {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	trace := f.trace
+	if trace == "" {
+		trace = traceOf(f.name)
+	}
+	if logFormat != "json" {
+		if trace != "" {
+			msg = "[" + trace + "] " + msg
+		}
+		logger.Check(level, msg).Write()
+		return
+	}
+	var fields []zap.Field
+	for _, s := range []struct{ key, value string }{
+		{"action", f.action}, {"object", f.object}, {"name", f.name}, {"namespace", f.namespace},
+	} {
+		if s.value != "" {
+			fields = append(fields, zap.String(s.key, s.value))
+		}
+	}
+	if f.elapsed != 0 {
+		fields = append(fields, zap.Float64("elapsed", f.elapsed.Seconds()))
+	}
+	if f.err != nil {
+		fields = append(fields, zap.Error(f.err))
+	}
+	if trace != "" {
+		fields = append(fields, zap.String("trace", trace))
+	}
+	logger.Check(level, msg).Write(fields...)
+}
+
+// traces are the trace ids of the cycles in progress, by the name of their
+// objects.
+var traces sync.Map
+
+// traceLabel labels the objects of a cycle with its trace id, so that the
+// cleanup of objects left behind can be traced to the operation creating them.
+const traceLabel = "kubernoisy-trace"
+
+// traceLabels adds the trace label of the cycle of the object name to labels,
+// if it has a trace id.
+func traceLabels(name string, labels map[string]string) map[string]string {
+	if trace := traceOf(name); trace != "" {
+		labels[traceLabel] = trace
+	}
+	return labels
+}
+
+// startTrace starts the span of the cycle of the objects named name if
+// tracing is enabled, and assigns it a trace id, that of its span if any, if
+// trace ids are enabled. It returns the function ending the trace.
+func startTrace(name string) func() {
+	id, endSpan := "", func() {}
+	if tracerProvider != nil {
+		id, endSpan = startOpSpan(name)
+	}
+	if !traceIDs {
+		return endSpan
+	}
+	if id == "" {
+		id = RandStringBytes(8)
+	}
+	traces.Store(name, id)
+	return func() {
+		traces.Delete(name)
+		endSpan()
+	}
+}
+
+// traceOf returns the trace id of the cycle of the object or host name, e.g.
+// of a service from the name of one of its pods, or "" if none.
+func traceOf(name string) string {
+	if !traceIDs {
+		return ""
+	}
+	if id, ok := loadByName(&traces, name); ok {
+		return id.(string)
+	}
+	return ""
+}
+
+// loadByName returns the value of m stored under the cycle name of the object
+// or host name, found by stripping the domain of a host and then the "-"
+// suffixes of object names derived from the cycle name.
+func loadByName(m *sync.Map, name string) (interface{}, bool) {
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	for name != "" {
+		if v, ok := m.Load(name); ok {
+			return v, true
+		}
+		i := strings.LastIndex(name, "-")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return nil, false
+}
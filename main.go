@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"math/rand"
@@ -8,215 +9,1145 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"golang.org/x/net/proxy"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 var (
-	ops float64
-
-	timeout   time.Duration
-	verbose   bool
-	namespace string
-	promaddr  string
-
-	OperationCount = promauto.NewCounterVec(prometheus.CounterOpts{
-		Namespace: "kubernoisy",
-		Name:      "action_count_total",
-		Help:      "Counter of object actions",
-	}, []string{"object", "action"})
-
-	ValidationFailCount = promauto.NewCounterVec(prometheus.CounterOpts{
-		Namespace: "kubernoisy",
-		Name:      "validation_fail_count_total",
-		Help:      "Counter of validation failures",
-	}, []string{"action"})
-
-	ValidationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: "kubernoisy",
-		Name:      "validation_duration_seconds",
-		Buckets:   prometheus.LinearBuckets(0, 1, 30), // from 0.1s to 8 seconds
-		Help:      "Delay to reflect in DNS record",
-	}, []string{"action"})
+	ops           float64
+	profile       string
+	profilePeriod time.Duration
+	burstOps      float64
+	burstDuration time.Duration
+	sineAmplitude float64
+	ramp          bool
+	rampFrom      float64
+	rampTo        float64
+	rampDuration  time.Duration
+
+	timeout       time.Duration
+	timeoutJitter float64
+	queryTimeout  time.Duration
+	verbose       bool
+	logFormat     string
+	traceIDs      bool
+
+	otlpEndpoint     string
+	otlpInsecure     bool
+	traceSampleRatio float64
+	namespace        string
+	namespaceOrder   string
+	clusterDomain    string
+	externalName     string
+
+	createNamespaceFlag  bool
+	namespaceLabels      = keyValues{}
+	namespaceAnnotations = keyValues{}
+	promaddr             string
+	clusterName          string
+	nativeHistograms     bool
+	bucketLayout         string
+	bucketStart          float64
+	bucketWidth          float64
+	bucketCount          int
+	kubeconfig           string
+	kubeContext          string
+
+	httpReadHeaderTimeout time.Duration
+	httpWriteTimeout      time.Duration
+
+	debugAddr string
+
+	pushgateway  string
+	pushJob      string
+	pushInterval time.Duration
+
+	snapshotFile     string
+	snapshotInterval time.Duration
+
+	replicas      int
+	batchSize     int
+	maxInflight   int
+	inflightQueue int
+	onFailure     string
+
+	createOrder     string
+	serverSideApply bool
+	ipFamilyPolicy  string
+	fieldManager    string
+	ssaForce        bool
+
+	errorBackoffBase  time.Duration
+	errorBackoffMax   time.Duration
+	errorBackoffAfter int
+	topologyHints     bool
+	execPodRef        string
+	mimicPodRef       string
+
+	resolverFamily   string
+	dnsSourceIPStr   string
+	requireResolvers string
+	dnsReplicas      string
+	dnsReplicasEvery time.Duration
+	dnsServer        string
+	directDNS        bool
+	nodeLocalDNS     bool
+	nodeLocalDNSIP   string
+	dnsProxy         string
+	lookupFunc       string
+
+	sampleReservoir     int
+	summaryQuantilesStr string
+	summaryQuantiles    []float64
+	summaryTimeout      time.Duration
+
+	junitReport           string
+	reportFile            string
+	runDuration           time.Duration
+	totalOps              int64
+	maxFailureRate        float64
+	maxP99                time.Duration
+	junitFailureThreshold float64
+
+	object          string
+	image           string
+	connectMode     string
+	connectImage    string
+	connectTimeout  time.Duration
+	imagePullSecret string
+	containerPort   int
+	extraPorts      int
+	endpointCIDRStr string
+	endpointQuorum  float64
+	jobDuration     time.Duration
+	scaleUp         int
+	scaleChurn      int
+	verifyPodRecord bool
+	serviceTeardown bool
+
+	verifyAllRecords     bool
+	recordType           string
+	verifySvcSuffix      bool
+	verifyTerminatingPod bool
+	verifyServiceUpdate  bool
+	reuseName            bool
+	verifyContent        bool
+	dnssec               bool
+	verifySRVFields      bool
+	srvPriority          int
+	srvWeight            int
+	createRetries        int
+	createRetryDelay     time.Duration
+	apiQPS               float64
+	apiBurst             int
+	apiTimeout           time.Duration
+	retryBudget          int64
+	verifyConcurrency    int
+	verifySampleRate     float64
+	minVerifyDuration    time.Duration
+	pollInterval         time.Duration
+	noRetryVerify        bool
+	drainTimeout         time.Duration
+	cleanupOnStart       bool
+	objectTTL            time.Duration
+	orphanInterval       time.Duration
+	preVerifyQuery       bool
+	preVerifyDelay       time.Duration
+	verifyPodZone        bool
+	readinessGate        bool
+	measureTTL           bool
+	ttlExpiry            bool
+	checkUniqueIPs       bool
+	watchEndpointsAPI    bool
+	recordNodes          bool
+	verifyNodes          string
+	kinds                string
+	noiseSize            int
+	noiseUpdates         int
+	chaos                float64
+	chaosAbandonRatio    float64
+	ingressDomain        string
+	ingressClass         string
+	ingressVerify        string
+	gateway              string
+	scenarioFile         string
+	podTemplateFile      string
+	endpointsAPI         string
+	dnsConfigMap         string
+
+	backgroundListInterval time.Duration
+	standingCount          int
+	prepopulateCount       int
+	prepopulateRate        float64
+	prepopulateEndpoints   int
+	standingInterval       time.Duration
+	observeSelector        string
+	observeInterval        time.Duration
+	namespaceRecreate      time.Duration
+	namespaceCount         int
+	namespaceChurn         time.Duration
+	namespacePrefix        string
+	heartbeatInterval      time.Duration
+	readyWindow            time.Duration
+	liveWindow             time.Duration
+	healthInterval         time.Duration
+	readyKeepUp            float64
+	readyFailStreak        int
+
+	shardGroup     string
+	shardID        string
+	shardNamespace string
+	shardInterval  time.Duration
+
+	execPod        *execResolver
+	resolver       dnsResolver = net.DefaultResolver
+	resolverServer string
+	dnsSourceIP    net.IP
+	dnsProxyDialer proxy.Dialer
+
+	requiredResolvers []namedResolver
+
+	endpointCIDR *net.IPNet
 )
 
 func main() {
-	flag.Float64Var(&ops, "ops", 1, "Operations per second")
+	ops = 1
+	flag.Var(opsFlag{&ops}, "ops", "Operations per second, or a comma separated list of object=ops (e.g. pod=2,service=0.5,configmap=10) churning each object at its own rate, as a workload named after it")
+	flag.StringVar(&profile, "profile", "constant", "Load profile of the operations per second: constant, ramp (as with ramp), burst (burst-ops for burst-duration of every profile-period), sine (ops varying by sine-amplitude over each profile-period) or poisson (ops with exponentially distributed delays)")
+	flag.DurationVar(&profilePeriod, "profile-period", 10*time.Minute, "Period of the burst and sine load profiles")
+	flag.Float64Var(&burstOps, "burst-ops", 0, "Operations per second during a burst, defaults to ten times ops")
+	flag.DurationVar(&burstDuration, "burst-duration", time.Minute, "Duration of each burst, at the start of each profile period")
+	flag.Float64Var(&sineAmplitude, "sine-amplitude", 0, "Amplitude of the sine load profile, less than ops, defaults to half of ops")
+	flag.BoolVar(&ramp, "ramp", false, "Ramp the operations per second linearly from ramp-from to ramp-to over ramp-duration, then hold")
+	flag.Float64Var(&rampFrom, "ramp-from", 1, "Operations per second at the start of the ramp")
+	flag.Float64Var(&rampTo, "ramp-to", 0, "Operations per second at the end of the ramp, defaults to ops")
+	flag.DurationVar(&rampDuration, "ramp-duration", 10*time.Minute, "Duration of the ramp")
 	flag.StringVar(&promaddr, "prom", ":9696", "Prometheus endpoint")
-	flag.StringVar(&namespace, "namespace", "load-test", "Namespace to operate in")
+	flag.DurationVar(&httpReadHeaderTimeout, "http-read-header-timeout", 10*time.Second, "Timeout for reading metrics request headers")
+	flag.DurationVar(&httpWriteTimeout, "http-write-timeout", 30*time.Second, "Timeout for writing metrics responses")
+	flag.StringVar(&debugAddr, "debug-http", "", "Listen address for debug endpoints, disabled if empty")
+	flag.StringVar(&clusterName, "cluster-name", "", "Value of a cluster label added to all metrics, defaults to the kubeconfig cluster out of cluster, omitted if empty")
+	flag.StringVar(&bucketLayout, "buckets", "linear", "Layout of the validation latency histogram buckets: linear (bucket-count buckets bucket-width apart) or exponential (bucket-count buckets growing by a factor of bucket-width), from bucket-start")
+	flag.Float64Var(&bucketStart, "bucket-start", 0, "Upper bound in seconds of the first validation latency bucket")
+	flag.Float64Var(&bucketWidth, "bucket-width", 1, "Width in seconds of the linear validation latency buckets, or growth factor of the exponential ones")
+	flag.IntVar(&bucketCount, "bucket-count", 30, "Number of validation latency buckets")
+	flag.BoolVar(&nativeHistograms, "native-histograms", false, "Also expose the latency histograms as Prometheus native histograms")
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "Kubeconfig to use, also in a cluster if set, defaults out of cluster to $KUBECONFIG then ~/.kube/config")
+	flag.StringVar(&kubeContext, "context", "", "Kubeconfig context to use, also in a cluster if set, defaults to the current context")
+	flag.StringVar(&pushgateway, "pushgateway", "", "Pushgateway URL to also push metrics to, periodically and on exit")
+	flag.StringVar(&pushJob, "push-job", "kubernoisy", "Job label of metrics pushed to the pushgateway")
+	flag.DurationVar(&pushInterval, "push-interval", 15*time.Second, "Interval between pushes to the pushgateway")
+	flag.StringVar(&snapshotFile, "metrics-snapshot-file", "", "CSV file to periodically append metric values to, disabled if empty")
+	flag.DurationVar(&snapshotInterval, "metrics-snapshot-interval", time.Minute, "Interval between metric snapshots")
+	flag.StringVar(&namespace, "namespace", "load-test", "Namespace to operate in, or a comma separated list of namespaces to spread the load across")
+	flag.StringVar(&namespaceOrder, "namespace-order", "round-robin", "Order in which cycles pick one of several namespaces: round-robin or random")
+	flag.StringVar(&clusterDomain, "cluster-domain", "cluster.local", "Cluster domain of the DNS names to verify")
+	flag.StringVar(&externalName, "external-name", "", "Name that externalname services alias, kubernetes.default.svc in the cluster domain if empty")
+	flag.BoolVar(&createNamespaceFlag, "create-namespace", false, "Create the namespaces if they do not exist")
+	flag.Var(namespaceLabels, "namespace-label", "Label (key=value) of the namespace when creating it, repeatable")
+	flag.Var(namespaceAnnotations, "namespace-annotation", "Annotation (key=value) of the namespace when creating it, repeatable")
 	flag.DurationVar(&timeout, "timeout", 30*time.Minute, "Timeout for validation")
-	flag.BoolVar(&verbose, "verbose", false, "Verbose log output")
+	flag.Float64Var(&timeoutJitter, "timeout-jitter", 0, "Fraction by which to randomly vary each validation timeout, e.g. 0.1 for ±10%")
+	flag.DurationVar(&queryTimeout, "query-timeout", 0, "Timeout for each individual DNS lookup within a validation, the resolver's own if 0")
+	flag.DurationVar(&heartbeatInterval, "heartbeat", 0, "Interval at which to log a progress summary, disabled if 0")
+	flag.DurationVar(&readyWindow, "ready-window", 5*time.Minute, "Window within which an operation must have completed successfully for /readyz to report ready")
+	flag.DurationVar(&liveWindow, "live-window", 5*time.Minute, "Window within which the API server must have answered a health check and the ops ticker ticked for /healthz to report live")
+	flag.DurationVar(&healthInterval, "health-interval", 10*time.Second, "Interval of the API server health checks and of the measurement of the fraction of the operations due started")
+	flag.Float64Var(&readyKeepUp, "ready-keep-up", 0, "Fraction of the operations due at the ticks of the last health interval that must have been started for /readyz to report ready, disabled if 0")
+	flag.IntVar(&readyFailStreak, "ready-fail-streak", 0, "Operations failing verification in a row from which /readyz reports not ready, disabled if 0")
+	flag.BoolVar(&verbose, "verbose", false, "Log debug events too, e.g. the creates and deletes of each object")
+	flag.StringVar(&logFormat, "log-format", "text", "Log format: text, or json for one object per line")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector (host:port) to export a trace of each operation to, with spans for its creates, DNS verifications and deletes, disabled if empty")
+	flag.BoolVar(&otlpInsecure, "otlp-insecure", false, "Export traces to the otlp-endpoint over plain HTTP rather than HTTPS")
+	flag.Float64Var(&traceSampleRatio, "trace-sample-ratio", 1, "Fraction of the operations traced with otlp-endpoint")
+	flag.BoolVar(&traceIDs, "trace-ids", false, "Tag the log lines and objects of each operation with a trace id unique to the operation, by default with the json log format")
+	flag.StringVar(&scenarioFile, "config", "", "Scenario file of workloads to run concurrently, each of an object at its own rate, with its own labels, record type and timeout, instead of the single workload of the flags")
+	flag.Float64Var(&chaos, "chaos", 0, "Probability of each chaos action in a service mode operation: swapping the create or delete order of pods and service, colliding with the name of the service, and recreating the service right after deleting it, disabled if 0")
+	flag.Float64Var(&chaosAbandonRatio, "chaos-abandon", 0, "Fraction of service mode operations leaving their objects behind once verified, to the object-ttl collection or the cleanup on exit")
+	flag.IntVar(&noiseSize, "noise-size", 18, "Size in bytes of the data payload of each ConfigMap and Secret churned")
+	flag.IntVar(&noiseUpdates, "noise-updates", 0, "Times the data payload of each ConfigMap and Secret churned is replaced between its create and delete")
+	flag.StringVar(&kinds, "kinds", "", "Comma separated additional object kinds to churn each tick alongside the object: endpoints (Endpoints written directly behind a selectorless headless service), configmap or secret (created, updated noise-updates times and deleted, not verified), ingress or httproute (routing a host to a headless service, verified per ingress-verify)")
+	flag.StringVar(&ingressDomain, "ingress-domain", "kubernoisy.example", "Domain of the hosts of the ingress and httproute kinds, each routing <name>.<domain>")
+	flag.StringVar(&ingressClass, "ingress-class", "", "Ingress class of the ingress kind, the default class if empty")
+	flag.StringVar(&ingressVerify, "ingress-verify", "status", "Verification of the ingress and httproute kinds: status (admitted by a controller), dns (host resolving, e.g. through external-dns) or none")
+	flag.StringVar(&gateway, "gateway", "", "Gateway (namespace/name, or name in the namespace of the route) the httproute kind attaches to")
+	flag.StringVar(&object, "object", "service", "Objects to churn: service (pods behind a headless service), pod (pods only), endpointslice (endpointslices written directly behind a headless service), endpoints (the same with endpoints), clusterip (ClusterIP services recreated under the same name), job (jobs that complete behind a headless service), deployment (a deployment behind a headless service), statefulset (a statefulset and its governing headless service), externalname (ExternalName services aliasing the external name), or configmap or secret (churned for API server and etcd load only, not verified)")
+	flag.StringVar(&podTemplateFile, "pod-template", "", "YAML PodSpec file used as the base of created pods, e.g. for tolerations, node selectors, resources or sidecars, with the name, labels and ports injected")
+	flag.StringVar(&image, "image", "gcr.io/google_containers/pause:3.2", "Image of the pods")
+	flag.StringVar(&connectMode, "connect", "", "Once a service resolves, check its answers are reachable on the container port: tcp (connect) or http (GET), disabled if empty")
+	flag.StringVar(&connectImage, "connect-image", "registry.k8s.io/e2e-test-images/agnhost:2.39", "Image of the pods with connect, serving HTTP on the container port with netexec")
+	flag.DurationVar(&connectTimeout, "connect-timeout", time.Second, "Timeout of each connect attempt")
+	flag.StringVar(&imagePullSecret, "image-pull-secret", "", "Image pull secret of the pods, none if empty")
+	flag.IntVar(&containerPort, "container-port", 1234, "Port of the pods and services")
+	flag.IntVar(&extraPorts, "extra-ports", 0, "Number of additional named ports of the pods and services, following the container port, each verified by its own SRV record")
+	flag.IntVar(&scaleChurn, "scale-churn", 0, "Times to scale each deployment back down and up again by scale-up once scaled up, verifying the addresses answered match the ready replicas")
+	flag.IntVar(&scaleUp, "scale-up", 0, "Replicas to add to each deployment once it resolves, recording the time for each added endpoint to appear, in deployment mode")
+	flag.DurationVar(&jobDuration, "job-duration", 30*time.Second, "Time the pods of each job run before completing in job mode")
+	flag.Float64Var(&endpointQuorum, "endpoint-quorum", 1, "Fraction of the expected addresses that must resolve for an add verification to succeed, where they are known")
+	flag.StringVar(&endpointCIDRStr, "endpoint-cidr", "", "Range to allocate endpoint addresses from sequentially in endpointslice mode")
+	flag.StringVar(&ipFamilyPolicy, "ip-family-policy", "", "IP family policy of created services (SingleStack, PreferDualStack or RequireDualStack), the cluster default if empty")
+	flag.BoolVar(&verifyPodRecord, "verify-pod-record", false, "Verify the pod A record of each pod in pod mode")
+	flag.BoolVar(&serviceTeardown, "service-teardown", false, "Delete only the service and verify its record is removed while the pods still run")
+	flag.StringVar(&recordType, "record-type", "IP", "Record type to verify services by in service mode: IP (any address), A, AAAA or SRV")
+	flag.IntVar(&createRetries, "create-retries", 0, "Times to retry creating or deleting a pod or service that failed transiently, e.g. throttled, timed out or refused")
+	flag.Float64Var(&apiQPS, "api-qps", 0, "Requests per second to the API server the client is limited to, the client-go default (5) if 0")
+	flag.IntVar(&apiBurst, "api-burst", 0, "Requests to the API server the client may burst to above api-qps, the client-go default (10) if 0")
+	flag.DurationVar(&apiTimeout, "api-timeout", 0, "Timeout of each API request of the operations, none if 0")
+	flag.Int64Var(&retryBudget, "retry-budget", 0, "Total create retries allowed over the whole run, after which creates are no longer retried, unlimited if 0")
+	flag.DurationVar(&createRetryDelay, "create-retry-delay", 100*time.Millisecond, "Delay before the first create retry, doubled for each following retry")
+	flag.BoolVar(&verifySRVFields, "verify-srv-fields", false, "Once SRV records resolve, also verify their priority and weight")
+	flag.IntVar(&srvPriority, "srv-priority", 0, "Priority SRV records are expected to have with verify-srv-fields")
+	flag.IntVar(&srvWeight, "srv-weight", 0, "Weight SRV records are expected to have with verify-srv-fields, 100 shared evenly among the records as by CoreDNS if 0")
+	flag.BoolVar(&dnssec, "dnssec", false, "Once an address record resolves, also validate its DNSSEC signatures, failing the verification if invalid")
+	flag.BoolVar(&verifyContent, "verify-content", false, "Only verify a service once it resolves to exactly the ips of its pods, as assigned by the API, rather than to any address")
+	flag.BoolVar(&reuseName, "reuse-name", false, "Once a service resolves, delete and recreate it and its pods under the same name, and verify it resolves to the new pod ips rather than stale old ones")
+	flag.BoolVar(&verifyServiceUpdate, "verify-update", false, "Once a service resolves, flip its selector to a spare pod and back, and record the time until each change is answered")
+	flag.BoolVar(&verifyTerminatingPod, "verify-terminating", false, "Once a service resolves, gracefully delete its first pod and record the time until its ips are no longer answered")
+	flag.BoolVar(&verifySvcSuffix, "verify-svc-suffix", false, "Once a service resolves, also verify its <name>.<namespace>.svc form resolves through the search path")
+	flag.BoolVar(&verifyAllRecords, "verify-all-records", false, "Verify each applicable record type (A, AAAA, SRV, PTR) of services rather than any address")
+	flag.IntVar(&verifyConcurrency, "verify-concurrency", 0, "Maximum concurrent DNS verifications, 0 for unlimited")
+	flag.BoolVar(&preVerifyQuery, "pre-verify-query", false, "Look up each service once right after creating it, likely caching a negative answer, before verifying it")
+	flag.DurationVar(&preVerifyDelay, "pre-verify-delay", 0, "Delay between the pre-verify query and the verification")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 0, "On exit, time to keep verifying operations in flight before cleaning up, not draining if 0")
+	flag.DurationVar(&objectTTL, "object-ttl", 0, "Age beyond which objects in the namespaces, e.g. left behind by a crashed run, are periodically deleted, disabled if 0")
+	flag.DurationVar(&orphanInterval, "orphan-interval", time.Minute, "Interval at which to delete objects older than the object-ttl")
+	flag.BoolVar(&cleanupOnStart, "cleanup-on-start", false, "On start, delete objects left in the namespace by a previous run")
+	flag.BoolVar(&noRetryVerify, "no-retry-verify", false, "Verify each record with a single lookup right after the create or delete, failing on a miss rather than polling")
+	flag.DurationVar(&pollInterval, "poll-interval", time.Second, "Interval between the DNS lookups of a verification")
+	flag.DurationVar(&minVerifyDuration, "min-verify-duration", 0, "Time a record must stay present on every poll before an add verification succeeds")
+	flag.Float64Var(&verifySampleRate, "verify-sample-rate", 1, "Fraction of operations to verify in DNS, from 0 to 1; the rest are created and deleted without verification")
+	flag.BoolVar(&verifyPodZone, "verify-pod-zone", false, "Verify the pod record of each service endpoint resolves to the same address")
+	flag.BoolVar(&readinessGate, "readiness-gate", false, "Create pods with a readiness gate, and measure the time from opening it to the service resolving")
+	flag.BoolVar(&measureTTL, "measure-ttl", false, "Compare the delete propagation delay with the ttl of the service record")
+	flag.BoolVar(&ttlExpiry, "check-ttl-expiry", false, "Resolve each service again just past the ttl of its record, and count resolvers that do not appear to look it up afresh")
+	flag.BoolVar(&checkUniqueIPs, "check-unique-ips", false, "Count live services resolving to the same address as another")
+	flag.IntVar(&namespaceCount, "namespace-count", 0, "Number of namespaces to spread the load across, named after the namespace with a -<index> suffix, disabled if 0")
+	flag.DurationVar(&namespaceChurn, "namespace-churn", 0, "Interval at which to create a namespace of the namespace count and rotate out the oldest, deleting it once its operations are done, disabled if 0")
+	flag.DurationVar(&namespaceRecreate, "namespace-recreate", 0, "Interval at which to delete and recreate the namespace, with the objects in it, disabled if 0")
+	flag.StringVar(&verifyNodes, "verify-nodes", "", "Comma separated nodes to pin pods to in turn, recording single pod latencies by node as with record-pod-nodes")
+	flag.BoolVar(&recordNodes, "record-pod-nodes", false, "Count the nodes verified pods are scheduled on, and record single pod latencies by node")
+	flag.BoolVar(&watchEndpointsAPI, "watch-endpoints", false, "Watch the endpoints API and record the time for services to have ready endpoints")
+	flag.StringVar(&endpointsAPI, "endpoints-api", "auto", "Endpoints API to watch: auto (detect), endpoints or endpointslice")
+	flag.StringVar(&dnsConfigMap, "watch-dns-config", "", "DNS server configmap (namespace/name, e.g. kube-system/coredns) to watch for config changes, disabled if empty")
+	flag.StringVar(&observeSelector, "observe-selector", "", "Only verify that the existing services matching this label selector resolve, creating nothing")
+	flag.DurationVar(&observeInterval, "observe-interval", 10*time.Second, "Interval between verifications of observed services")
+	flag.DurationVar(&backgroundListInterval, "background-list", 0, "Interval at which to list pods and services in the background, disabled if 0")
+	flag.IntVar(&standingCount, "standing-services", 0, "Number of ClusterIP services to create on start and keep for the run, sampling their lookup latency under churn, disabled if 0")
+	flag.IntVar(&prepopulateCount, "prepopulate", 0, "Number of headless services with Endpoints to create before the operations start and delete at the end, so that they run against that many records, disabled if 0")
+	flag.Float64Var(&prepopulateRate, "prepopulate-rate", 50, "Services per second to prepopulate and to delete at the end")
+	flag.IntVar(&prepopulateEndpoints, "prepopulate-endpoints", 1, "Addresses of the endpoint-cidr in the Endpoints of each prepopulated service")
+	flag.DurationVar(&standingInterval, "standing-sample-interval", 100*time.Millisecond, "Interval between lookups of a random standing service")
+	flag.IntVar(&maxInflight, "max-inflight", 0, "Maximum operations in flight, skipping ticks beyond it, unlimited if 0")
+	flag.IntVar(&inflightQueue, "inflight-queue", 0, "Operations due with max-inflight operations in flight to queue until one finishes, rather than skip")
+	flag.IntVar(&batchSize, "batch-size", 1, "Objects to create concurrently in each operation, for burst testing")
+	flag.StringVar(&onFailure, "on-failure", "cleanup", "On a failed create, verify or delete: cleanup (delete what was created) or abort (leave it until exit)")
+	flag.DurationVar(&errorBackoffBase, "error-backoff", 0, "Initial delay before starting new operations after consecutive failed operations, doubling while they fail, disabled if 0")
+	flag.DurationVar(&errorBackoffMax, "error-backoff-max", time.Minute, "Maximum delay before starting new operations after consecutive failed operations")
+	flag.IntVar(&errorBackoffAfter, "error-backoff-after", 3, "Consecutive failed operations after which to back off")
+	flag.StringVar(&createOrder, "create-order", "pod-first", "Order to create the pods and service of each operation in: pod-first, service-first or random")
+	flag.BoolVar(&serverSideApply, "server-side-apply", false, "Create pods and services with server-side apply rather than create")
+	flag.StringVar(&fieldManager, "field-manager", "kubernoisy", "Field manager name for server-side apply, unique per instance to avoid conflicts")
+	flag.BoolVar(&ssaForce, "ssa-force", false, "Retry server-side apply conflicts forcing ownership of the fields")
+	flag.IntVar(&replicas, "replicas", 1, "Pods to create behind each service")
+	flag.BoolVar(&topologyHints, "topology-hints", false, "Enable topology aware hints on services, spread pods across zones and record the zones of answers relative to the zone of the exec-pod")
+	flag.BoolVar(&directDNS, "direct-dns", false, "Send verification queries with a DNS client of its own, for exactly the names and record types verified without search path, to the dns-server and require-resolvers, or the first resolv.conf nameserver")
+	flag.StringVar(&dnsServer, "dns-server", "", "DNS server (host:port) to send all verification queries to, rather than the resolv.conf nameservers")
+	flag.StringVar(&mimicPodRef, "mimic-pod", "", "Verify DNS with the search path, ndots and nameservers of this pod (namespace/name), replicating its resolv.conf")
+	flag.StringVar(&execPodRef, "exec-pod", "", "Verify DNS by running nslookup in this pod (namespace/name)")
+	flag.StringVar(&summaryQuantilesStr, "summary-quantiles", "0.5,0.9,0.99", "Comma separated validation latency quantiles of the exit summary, none if empty")
+	flag.DurationVar(&summaryTimeout, "summary-timeout", 10*time.Second, "Time after which the exit summary skips the remaining actions, unlimited if 0")
+	flag.IntVar(&sampleReservoir, "sample-reservoir", 10000, "Validation latency samples kept per action for the exit summary percentiles")
+	flag.StringVar(&reportFile, "report", "", "File to write a summary of the run to on exit, as CSV if named .csv, otherwise as JSON, disabled if empty")
+	flag.Int64Var(&totalOps, "total-ops", 0, "Operations to perform before cleaning up and exiting as on a signal, once they are done, unlimited if 0")
+	flag.StringVar(&shardGroup, "shard-group", "", "Name of a group of kubernoisy replicas splitting ops between them, coordinated by a leader through leases and a ConfigMap, disabled if empty")
+	flag.StringVar(&shardID, "shard-id", "", "Unique id of this replica in the shard group, defaults to the hostname")
+	flag.StringVar(&shardNamespace, "shard-namespace", "", "Namespace of the leases and ConfigMap of the shard group, defaults to the namespace kubernoisy runs in, or the first namespace")
+	flag.DurationVar(&shardInterval, "shard-interval", 10*time.Second, "Interval at which shard group members renew their lease and read their share of ops, the leader lease lasting three intervals")
+	flag.DurationVar(&runDuration, "duration", 0, "Time to run for before cleaning up and exiting as on a signal, until signalled if 0")
+	flag.Float64Var(&maxFailureRate, "max-failure-rate", 1, "Failure rate of operations above which kubernoisy exits non-zero")
+	flag.DurationVar(&maxP99, "max-p99", 0, "Validation p99 latency of any action above which kubernoisy exits non-zero, disabled if 0")
+	flag.StringVar(&junitReport, "junit-report", "", "File to write the exit summary to as a JUnit XML report, disabled if empty")
+	flag.Float64Var(&junitFailureThreshold, "junit-failure-threshold", 0, "Failure rate above which a JUnit report test case fails")
+	flag.StringVar(&lookupFunc, "lookup-func", "ip", "Function used to resolve addresses: ip (LookupIP) or host (LookupHost)")
+	flag.StringVar(&dnsSourceIPStr, "dns-source-ip", "", "Local address to send DNS queries from")
+	flag.StringVar(&dnsProxy, "dns-proxy", "", "SOCKS5 (socks5://host:port) or HTTP CONNECT (http://host:port) proxy to send DNS queries through over tcp")
+	flag.StringVar(&dnsReplicas, "dns-replicas", "", "DNS service (namespace/name, e.g. kube-system/kube-dns) whose every ready endpoint must agree before a verification succeeds, recording the propagation to each and the skew between them, disabled if empty")
+	flag.DurationVar(&dnsReplicasEvery, "dns-replicas-interval", time.Minute, "Interval at which to rediscover the endpoints of the dns-replicas service")
+	flag.StringVar(&requireResolvers, "require-resolvers", "", "Comma separated nameservers (host[:port]) that must all agree before a verification succeeds")
+	flag.BoolVar(&nodeLocalDNS, "nodelocal-dns", false, "Verify DNS via the NodeLocal DNSCache of the node")
+	flag.StringVar(&nodeLocalDNSIP, "nodelocal-dns-ip", "169.254.20.10", "Link-local address NodeLocal DNSCache listens on")
+	flag.StringVar(&resolverFamily, "resolver-family", "", "Verify DNS only through a nameserver of this address family (ipv4 or ipv6)")
 
 	flag.Parse()
+	if err := setupLogFormat(); err != nil {
+		log.Fatal(err)
+	}
+	if logFormat == "json" {
+		// log aggregation correlates the events of an operation by trace id
+		traceIDs = true
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "trace-ids" {
+				traceIDs = f.Value.String() == "true"
+			}
+		})
+	}
+	if err := checkBuckets(); err != nil {
+		log.Fatal(err)
+	}
+	registerLatencyMetrics()
 
 	if ops <= 0 {
 		log.Fatal("ops cannot be <= 0")
 	}
+	if ramp {
+		if profile != "constant" && profile != "ramp" {
+			log.Fatal("ramp is the ramp profile, and cannot be used with another")
+		}
+		profile = "ramp"
+	}
+	if !profiles[profile] {
+		log.Fatalf("unknown profile %q", profile)
+	}
+	if profile == "ramp" {
+		if rampTo == 0 {
+			rampTo = ops
+		}
+		if rampFrom <= 0 || rampTo <= 0 {
+			log.Fatal("ramp-from and ramp-to cannot be <= 0")
+		}
+		if rampDuration <= 0 {
+			log.Fatal("ramp-duration cannot be <= 0")
+		}
+	}
+	if (profile == "burst" || profile == "sine") && profilePeriod <= 0 {
+		log.Fatal("profile-period cannot be <= 0")
+	}
+	if profile == "burst" {
+		if burstOps == 0 {
+			burstOps = 10 * ops
+		}
+		if burstOps <= 0 {
+			log.Fatal("burst-ops cannot be <= 0")
+		}
+		if burstDuration <= 0 || burstDuration >= profilePeriod {
+			log.Fatal("burst-duration must be > 0 and shorter than the profile-period")
+		}
+	}
+	if profile == "sine" {
+		if sineAmplitude == 0 {
+			sineAmplitude = ops / 2
+		}
+		if sineAmplitude < 0 || sineAmplitude >= ops {
+			log.Fatal("sine-amplitude must be > 0 and less than ops")
+		}
+	}
+	if (measureTTL || ttlExpiry) && execPodRef != "" {
+		log.Fatal("measure-ttl and check-ttl-expiry are not supported with exec-pod")
+	}
+	if topologyHints && execPodRef == "" {
+		log.Fatal("topology-hints needs exec-pod, the zone the answers are relative to")
+	}
+	if dnssec && execPodRef != "" {
+		log.Fatal("dnssec is not supported with exec-pod")
+	}
+	if srvPriority < 0 || srvPriority > 65535 || srvWeight < 0 || srvWeight > 65535 {
+		log.Fatal("srv-priority and srv-weight must be >= 0 and <= 65535")
+	}
+	if queryTimeout < 0 {
+		log.Fatal("query-timeout cannot be < 0")
+	}
+	if queryTimeout > 0 && execPodRef != "" {
+		log.Fatal("query-timeout is not supported with exec-pod")
+	}
+	if timeoutJitter < 0 || timeoutJitter >= 1 {
+		log.Fatal("timeout-jitter must be >= 0 and < 1")
+	}
+	cycle, ok := cycles[object]
+	if !ok {
+		log.Fatalf("unknown object %q", object)
+	}
+	extraKinds, err := parseKinds(kinds)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defaultWorkload = &workload{name: "default", object: object, cycle: withKinds(cycle, extraKinds), ops: ops, recordType: recordType, timeout: timeout}
+	if podTemplateFile != "" {
+		if podTemplate, err = loadPodTemplate(podTemplateFile); err != nil {
+			log.Fatalf("could not load pod-template %v: %v", podTemplateFile, err)
+		}
+	}
+	if objectOps != nil {
+		if scenarioFile != "" || profile != "constant" || observeSelector != "" {
+			log.Fatal("ops of each object are only supported with the constant profile, and not with config or observe-selector")
+		}
+		if workloads, err = objectWorkloads(objectOps, defaultWorkload, extraKinds); err != nil {
+			log.Fatalf("invalid ops: %v", err)
+		}
+	}
+	if scenarioFile != "" {
+		if workloads, err = loadScenario(scenarioFile, defaultWorkload, extraKinds); err != nil {
+			log.Fatalf("could not load scenario %v: %v", scenarioFile, err)
+		}
+		if profile != "constant" || observeSelector != "" {
+			log.Fatal("config is only supported with the constant profile, and not with observe-selector")
+		}
+	}
+	if noiseSize <= 0 || noiseSize > 1<<20 {
+		log.Fatal("noise-size must be > 0 and <= 1048576, the size limit of ConfigMaps and Secrets")
+	}
+	if noiseUpdates < 0 {
+		log.Fatal("noise-updates cannot be < 0")
+	}
+	if chaos < 0 || chaos > 1 || chaosAbandonRatio < 0 || chaosAbandonRatio > 1 {
+		log.Fatal("chaos and chaos-abandon must be >= 0 and <= 1")
+	}
+	if (chaos > 0 || chaosAbandonRatio > 0) && !usesObject("service") {
+		log.Fatal("chaos and chaos-abandon are only supported in service mode")
+	}
+	if ingressVerify != "status" && ingressVerify != "dns" && ingressVerify != "none" {
+		log.Fatalf("unknown ingress-verify %q", ingressVerify)
+	}
+	if errs := validation.IsDNS1123Subdomain(ingressDomain); len(errs) > 0 {
+		log.Fatalf("invalid ingress-domain %q: %v", ingressDomain, strings.Join(errs, "; "))
+	}
+	if usesKind("httproute") && gateway == "" {
+		log.Fatal("the httproute kind requires a gateway")
+	}
+	if endpointQuorum <= 0 || endpointQuorum > 1 {
+		log.Fatal("endpoint-quorum must be > 0 and <= 1")
+	}
+	if prepopulateCount > 0 && endpointCIDRStr == "" {
+		log.Fatal("prepopulate requires an endpoint-cidr to allocate the endpoint addresses from")
+	}
+	if usesObject("endpointslice") || usesObject("endpoints") || prepopulateCount > 0 {
+		var err error
+		_, endpointCIDR, err = net.ParseCIDR(endpointCIDRStr)
+		if err != nil {
+			log.Fatalf("invalid endpoint-cidr: %v", err)
+		}
+		if endpointCIDRSize() < uint64(replicas) {
+			log.Fatalf("endpoint-cidr %v is too small for %d replicas", endpointCIDR, replicas)
+		}
+	}
+	if scaleUp < 0 {
+		log.Fatal("scale-up cannot be < 0")
+	}
+	if scaleChurn < 0 || (scaleChurn > 0 && scaleUp == 0) {
+		log.Fatal("scale-churn cannot be < 0, and requires scale-up")
+	}
+	if usesObject("job") && jobDuration < time.Second {
+		log.Fatal("job-duration cannot be < 1s")
+	}
+	if createOrder != "pod-first" && createOrder != "service-first" && createOrder != "random" {
+		log.Fatalf("unknown create-order %q", createOrder)
+	}
+	if onFailure != "cleanup" && onFailure != "abort" {
+		log.Fatalf("unknown on-failure %q", onFailure)
+	}
+	if errorBackoffAfter < 1 {
+		log.Fatal("error-backoff-after cannot be < 1")
+	}
+	if maxInflight < 0 {
+		log.Fatal("max-inflight cannot be < 0")
+	}
+	for _, w := range allWorkloads() {
+		if objectTTL < 0 || (objectTTL > 0 && objectTTL <= 2*w.timeout) {
+			log.Fatal("object-ttl cannot be < 0, and must be longer than twice the timeout so that no live object is deleted")
+		}
+	}
+	if objectTTL > 0 && orphanInterval <= 0 {
+		log.Fatal("orphan-interval cannot be <= 0")
+	}
+	if inflightQueue < 0 || (inflightQueue > 0 && maxInflight == 0) {
+		log.Fatal("inflight-queue cannot be < 0, and requires max-inflight")
+	}
+	if batchSize < 1 {
+		log.Fatal("batch-size cannot be < 1")
+	}
+	if replicas < 1 {
+		log.Fatal("replicas cannot be < 1")
+	}
+	if verifyConcurrency < 0 {
+		log.Fatal("verify-concurrency cannot be < 0")
+	}
+	if preVerifyDelay < 0 {
+		log.Fatal("pre-verify-delay cannot be < 0")
+	}
+	if pollInterval <= 0 {
+		log.Fatal("poll-interval must be > 0")
+	}
+	if minVerifyDuration < 0 {
+		log.Fatal("min-verify-duration cannot be < 0")
+	}
+	if verifySampleRate < 0 || verifySampleRate > 1 {
+		log.Fatal("verify-sample-rate must be >= 0 and <= 1")
+	}
+	VerifySampleRate.Set(verifySampleRate)
+	if verifyConcurrency > 0 {
+		verifySlots = make(chan struct{}, verifyConcurrency)
+	}
+	if endpointsAPI != "auto" && endpointsAPI != "endpoints" && endpointsAPI != "endpointslice" {
+		log.Fatalf("unknown endpoints-api %q", endpointsAPI)
+	}
+	if namespaceCount < 0 {
+		log.Fatal("namespace-count cannot be < 0")
+	}
+	if namespaceCount > 0 {
+		if strings.Contains(namespace, ",") {
+			log.Fatal("namespace-count takes a single namespace to name the namespaces after")
+		}
+		namespacePrefix = namespace
+		namespace = strings.Join(countedNamespaces(namespacePrefix, namespaceCount), ",")
+		namespaceNext = namespaceCount
+	}
+	if namespaces, err = parseNamespaces(namespace); err != nil {
+		log.Fatal(err)
+	}
+	namespace = namespaces[0]
+	if verifyNodes != "" {
+		if pinNodes, err = parseNodes(verifyNodes); err != nil {
+			log.Fatal(err)
+		}
+		recordNodes = true
+	}
+	if namespaceOrder != "round-robin" && namespaceOrder != "random" {
+		log.Fatalf("unknown namespace-order %q", namespaceOrder)
+	}
+	if len(namespaces) > 1 {
+		// these operate on a single namespace
+		if namespaceRecreate > 0 {
+			log.Fatal("namespace-recreate cannot be used with several namespaces")
+		}
+		if watchEndpointsAPI {
+			log.Fatal("watch-endpoints cannot be used with several namespaces")
+		}
+		if observeSelector != "" {
+			log.Fatal("observe-selector cannot be used with several namespaces")
+		}
+	}
+	if namespaceChurn < 0 || (namespaceChurn > 0 && namespaceCount == 0) {
+		log.Fatal("namespace-churn cannot be < 0, and requires namespace-count")
+	}
+	if namespaceChurn > 0 {
+		// these rely on the namespaces staying in place
+		if namespaceRecreate > 0 || watchEndpointsAPI || observeSelector != "" || standingCount > 0 {
+			log.Fatal("namespace-churn cannot be used with namespace-recreate, watch-endpoints, observe-selector or standing-services")
+		}
+		createNamespaceFlag = true
+	}
+	if err := validateNamespaceMeta(); err != nil {
+		log.Fatal(err)
+	}
+	if namespaceRecreate > 0 && namespace == ownNamespace() {
+		log.Fatal("namespace-recreate would delete the namespace kubernoisy runs in")
+	}
+	if liveWindow <= 0 || healthInterval <= 0 {
+		log.Fatal("live-window and health-interval cannot be <= 0")
+	}
+	if interval, _ := tickInterval(lowestOps()); observeSelector == "" && liveWindow < interval {
+		log.Fatalf("live-window cannot be shorter than the tick interval %v of the lowest rate", interval)
+	}
+	if readyKeepUp < 0 || readyKeepUp > 1 {
+		log.Fatal("ready-keep-up must be >= 0 and <= 1")
+	}
+	if readyFailStreak < 0 {
+		log.Fatal("ready-fail-streak cannot be < 0")
+	}
+	if readyWindow <= 0 {
+		log.Fatal("ready-window cannot be <= 0")
+	}
+	if standingCount < 0 {
+		log.Fatal("standing-services cannot be < 0")
+	}
+	if prepopulateCount < 0 {
+		log.Fatal("prepopulate cannot be < 0")
+	}
+	if prepopulateCount > 0 && (prepopulateRate <= 0 || prepopulateEndpoints <= 0) {
+		log.Fatal("prepopulate-rate and prepopulate-endpoints must be > 0")
+	}
+	if standingCount > 0 && standingInterval <= 0 {
+		log.Fatal("standing-sample-interval cannot be <= 0")
+	}
+	if observeSelector != "" && observeInterval <= 0 {
+		log.Fatal("observe-interval cannot be <= 0")
+	}
+	if pushgateway != "" && pushInterval <= 0 {
+		log.Fatal("push-interval cannot be <= 0")
+	}
+	if snapshotFile != "" && snapshotInterval <= 0 {
+		log.Fatal("metrics-snapshot-interval cannot be <= 0")
+	}
+	if junitFailureThreshold < 0 || junitFailureThreshold > 1 {
+		log.Fatal("junit-failure-threshold must be >= 0 and <= 1")
+	}
+	if maxFailureRate < 0 || maxFailureRate > 1 {
+		log.Fatal("max-failure-rate must be >= 0 and <= 1")
+	}
+	if traceSampleRatio < 0 || traceSampleRatio > 1 {
+		log.Fatal("trace-sample-ratio must be >= 0 and <= 1")
+	}
+	if runDuration < 0 || maxP99 < 0 || totalOps < 0 {
+		log.Fatal("duration, total-ops and max-p99 cannot be < 0")
+	}
+	opsLeft = totalOps
+	if shardGroup != "" {
+		if errs := validation.IsDNS1123Subdomain(shardGroup); len(errs) > 0 {
+			log.Fatalf("invalid shard-group %v: %v", shardGroup, strings.Join(errs, "; "))
+		}
+		// the leader splits the constant ops of the default workload
+		if workloads != nil || profile != "constant" || observeSelector != "" {
+			log.Fatal("shard-group is only supported with the constant profile, and not with config or observe-selector")
+		}
+		if shardInterval <= 0 {
+			log.Fatal("shard-interval cannot be <= 0")
+		}
+		if shardID == "" {
+			if shardID, err = os.Hostname(); err != nil {
+				log.Fatalf("could not get hostname for shard-id: %v", err)
+			}
+		}
+		if shardNamespace == "" {
+			if shardNamespace = ownNamespace(); shardNamespace == "" {
+				shardNamespace = namespaces[0]
+			}
+		}
+	}
+	if summaryQuantiles, err = parseQuantiles(summaryQuantilesStr); err != nil {
+		log.Fatalf("invalid summary-quantiles: %v", err)
+	}
+	if err := checkListenAddrs(map[string]string{"prom": promaddr, "debug-http": debugAddr}); err != nil {
+		log.Fatal(err)
+	}
+	if sampleReservoir < 1 {
+		log.Fatal("sample-reservoir cannot be < 1")
+	}
+	if lookupFunc != "ip" && lookupFunc != "host" {
+		log.Fatalf("unknown lookup-func %q", lookupFunc)
+	}
+	LookupFuncInfo.WithLabelValues(lookupFunc).Set(1)
+	if execPodRef != "" && (dnsSourceIPStr != "" || requireResolvers != "" || dnsReplicas != "" || dnsProxy != "") {
+		log.Fatal("dns-source-ip, dns-proxy, require-resolvers and dns-replicas are not supported with exec-pod")
+	}
+	if dnsReplicas != "" && (requireResolvers != "" || dnsReplicasEvery <= 0) {
+		log.Fatal("dns-replicas cannot be used with require-resolvers, and needs a dns-replicas-interval > 0")
+	}
+	if (measureTTL || ttlExpiry) && dnsProxy != "" {
+		log.Fatal("measure-ttl and check-ttl-expiry are not supported with dns-proxy")
+	}
+	if directDNS && (execPodRef != "" || mimicPodRef != "" || dnsProxy != "" || verifySvcSuffix) {
+		log.Fatal("direct-dns is not supported with exec-pod, mimic-pod, dns-proxy or verify-svc-suffix")
+	}
+	if dnsServer != "" && (execPodRef != "" || mimicPodRef != "" || nodeLocalDNS || resolverFamily != "") {
+		log.Fatal("dns-server is not supported with exec-pod, mimic-pod, nodelocal-dns or resolver-family")
+	}
+	if ipFamilyPolicy != "" && !ipFamilyPolicies[ipFamilyPolicy] {
+		log.Fatalf("unknown ip-family-policy %q", ipFamilyPolicy)
+	}
+	if ipFamilyPolicy != "" && serverSideApply {
+		log.Fatal("ip-family-policy is not supported with server-side-apply")
+	}
+	if errs := validation.IsDNS1123Subdomain(clusterDomain); len(errs) > 0 {
+		log.Fatalf("invalid cluster-domain %q: %v", clusterDomain, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsDNS1123Subdomain(strings.TrimSuffix(externalNameTarget(), ".")); len(errs) > 0 {
+		log.Fatalf("invalid external-name %q: %v", externalName, strings.Join(errs, "; "))
+	}
+	if containerPort < 1 || containerPort > 65535 {
+		log.Fatal("container-port must be >= 1 and <= 65535")
+	}
+	if extraPorts < 0 || extraPorts > maxExtraPorts || containerPort+extraPorts > 65535 {
+		log.Fatalf("extra-ports must be >= 0 and <= %d, and leave the ports <= 65535", maxExtraPorts)
+	}
+	if verifyTerminatingPod && !onlyObject("service") {
+		log.Fatal("verify-terminating is only supported in service mode")
+	}
+	if connectMode != "" && connectMode != "tcp" && connectMode != "http" {
+		log.Fatalf("unknown connect mode %q", connectMode)
+	}
+	if connectMode != "" && (!usesObject("service") || connectTimeout <= 0) {
+		log.Fatal("connect is only supported in service mode, with a connect-timeout > 0")
+	}
+	if verifyServiceUpdate && !onlyObject("service") {
+		log.Fatal("verify-update is only supported in service mode")
+	}
+	if createRetries < 0 {
+		log.Fatal("create-retries cannot be < 0")
+	}
+	if apiQPS < 0 || apiBurst < 0 || apiTimeout < 0 {
+		log.Fatal("api-qps, api-burst and api-timeout cannot be < 0")
+	}
+	if createRetries > 0 && createRetryDelay <= 0 {
+		log.Fatal("create-retry-delay cannot be <= 0")
+	}
+	if retryBudget < 0 {
+		log.Fatal("retry-budget cannot be < 0")
+	}
+	retriesLeft = retryBudget
+	RetryBudgetRemaining.Set(float64(retryBudget))
+	if verifyContent && !onlyObject("service") {
+		log.Fatal("verify-content is only supported in service mode")
+	}
+	if reuseName && !onlyObject("service") {
+		log.Fatal("reuse-name is only supported in service mode")
+	}
+	if recordType != "IP" && recordType != "A" && recordType != "AAAA" && recordType != "SRV" {
+		log.Fatalf("unknown record-type %q", recordType)
+	}
+	if recordType != "IP" && (!onlyObject("service") || verifyAllRecords) {
+		log.Fatal("record-type is only supported in service mode without verify-all-records")
+	}
+	if mimicPodRef != "" && (execPodRef != "" || nodeLocalDNS || resolverFamily != "") {
+		log.Fatal("mimic-pod is not supported with exec-pod, nodelocal-dns or resolver-family")
+	}
+	if nodeLocalDNS && (execPodRef != "" || resolverFamily != "") {
+		log.Fatal("nodelocal-dns is not supported with exec-pod or resolver-family")
+	}
+	if nodeLocalDNS && net.ParseIP(nodeLocalDNSIP) == nil {
+		log.Fatalf("invalid nodelocal-dns-ip %q", nodeLocalDNSIP)
+	}
+	if resolverFamily != "" && resolverFamily != "ipv4" && resolverFamily != "ipv6" {
+		log.Fatalf("unknown resolver-family %q", resolverFamily)
+	}
 
 	// listen for signals
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 
 	// get k8s api connection
-	kapi, err := getAPIConn()
+	config, kapi, err := getAPIConn()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if err := checkNodes(kapi, pinNodes); err != nil {
+		log.Fatal(err)
+	}
+
+	if createNamespaceFlag {
+		for _, ns := range namespaces {
+			if err := createNamespace(kapi, ns); err != nil {
+				log.Fatalf("could not create namespace %v: %v", ns, err)
+			}
+		}
+	}
+
+	if cleanupOnStart {
+		logEvent(logFields{action: "cleanup"}, "Cleaned up %d objects left over from a previous run", reapObjects(kapi))
+	}
+
+	if err := setupResolver(config, kapi); err != nil {
+		log.Fatal(err)
+	}
+	if dnsReplicas != "" {
+		go refreshDNSReplicas(kapi, dnsReplicasEvery)
+	}
+
+	if watchEndpointsAPI {
+		api := endpointsAPI
+		if api == "auto" {
+			api = detectEndpointsAPI(kapi)
+		}
+		if err := watchEndpoints(kapi, api, make(chan struct{})); err != nil {
+			log.Fatal(err)
+		}
+		logEvent(logFields{action: "watch"}, "Watching %v for service readiness", api)
+	}
+
+	if dnsConfigMap != "" {
+		if err := watchDNSConfig(kapi, dnsConfigMap, make(chan struct{})); err != nil {
+			log.Fatal(err)
+		}
+		logEvent(logFields{action: "watch", object: "configmap"}, "Watching DNS config %v", dnsConfigMap)
+	}
+
 	// serve prometheus metrics
-	http.Handle("/metrics", promhttp.Handler())
-	go http.ListenAndServe(promaddr, nil)
+	if debugAddr != "" {
+		resetter = newResetGatherer(gatherer)
+		gatherer = resetter
+	}
+	if clusterName != "" {
+		gatherer = newLabelGatherer(gatherer, prometheus.Labels{"cluster": clusterName})
+	}
+	http.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})))
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+	server := &http.Server{
+		Addr:              promaddr,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		WriteTimeout:      httpWriteTimeout,
+	}
+	go serve(server, "metrics")
 
-	// start ops ticker
-	ticker := time.NewTicker(time.Duration(1/ops) * time.Second)
-	defer ticker.Stop()
+	// serve debug endpoints
+	var debugServer *http.Server
+	if debugAddr != "" {
+		debugServer = newDebugServer(kapi)
+		go serve(debugServer, "debug")
+	}
 
-	log.Printf("Performing %v operations per second", ops)
-	for {
-		select {
-		case <-ticker.C:
-			go func() {
-				// generate unique name
-				rando := "kubernoisy-" + RandStringBytes(18)
-
-				// create pod
-				pod := &v1.Pod{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      rando,
-						Namespace: namespace,
-						Labels:    map[string]string{"app": rando, "kubernoisy": "noise"},
-					},
-					Spec: v1.PodSpec{
-						Hostname: "pod",
-						Containers: []v1.Container{{
-							Name:  rando,
-							Image: "gcr.io/google_containers/pause:3.2",
-							Ports: []v1.ContainerPort{{Name: "kubernoisy", ContainerPort: 1234}},
-						}},
-					},
-				}
-				pod, err := kapi.CoreV1().Pods(namespace).Create(pod)
-				if err != nil {
-					log.Printf("could not create pod %v.%v: %v", rando, namespace, err)
-				} else {
-					OperationCount.WithLabelValues("pod", "add").Inc()
-				}
+	// push metrics for runs that may end before being scraped
+	var pusher *push.Pusher
+	if pushgateway != "" {
+		pusher = newPusher()
+		go pushMetrics(pusher)
+	}
 
-				// create headless service
-				svc := &v1.Service{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      rando,
-						Namespace: namespace,
-						Labels:    map[string]string{"kubernoisy": "noise"},
-					},
-					Spec: v1.ServiceSpec{
-						Ports:     []v1.ServicePort{{Name: "kubernoisy", Port: 1234}},
-						ClusterIP: v1.ClusterIPNone,
-						Type:      v1.ServiceTypeClusterIP,
-						Selector:  map[string]string{"app": rando},
-					},
-				}
-				svc, err = kapi.CoreV1().Services(namespace).Create(svc)
-				if err != nil {
-					log.Printf("could not create service %v.%v: %v", rando, namespace, err)
-				} else {
-					OperationCount.WithLabelValues("service", "add").Inc()
-				}
+	// snapshot metrics for runs without a scrape backend
+	if snapshotFile != "" {
+		go snapshotMetrics()
+	}
 
-				// verify via DNS in loop with timeout
-				verified := false
-				var elapsed time.Duration
-				for start := time.Now(); time.Since(start) < timeout; {
-					ips, err := net.LookupIP(rando)
-					if err == nil && len(ips) > 0 {
-						verified = true
-						break
-					}
-					time.Sleep(time.Second)
-					elapsed = time.Since(start)
-				}
-				if !verified {
-					ValidationFailCount.WithLabelValues("add").Inc()
-				} else {
-					ValidationDuration.WithLabelValues("add").Observe(elapsed.Seconds())
-				}
+	if heartbeatInterval > 0 {
+		go heartbeat(heartbeatInterval)
+	}
 
-				// delete pod
-				err = kapi.CoreV1().Pods(namespace).Delete(rando, &metav1.DeleteOptions{})
-				if err != nil {
-					debugf("could not delete pod pod.%v.%v: %v", rando, namespace, err)
-				} else {
-					OperationCount.WithLabelValues("pod", "delete").Inc()
-				}
+	// disrupt the namespace
+	if namespaceRecreate > 0 {
+		go recreateNamespace(kapi, namespaceRecreate)
+	}
+	if namespaceChurn > 0 {
+		go churnNamespaces(kapi, namespacePrefix, namespaceChurn)
+	}
 
-				// delete headless service
-				err = kapi.CoreV1().Services(namespace).Delete(rando, &metav1.DeleteOptions{})
-				if err != nil {
-					debugf("could not delete service %v.%v: %v", rando, namespace, err)
-				} else {
-					OperationCount.WithLabelValues("service", "delete").Inc()
-				}
+	// delete objects outliving their cycles
+	if objectTTL > 0 {
+		go collectOrphans(kapi, orphanInterval)
+	}
 
-				// verify via DNS in loop with timeout
-				verified = false
-				elapsed = 0
-				for start := time.Now(); time.Since(start) < timeout; {
-					_, err := net.LookupIP(rando)
-					if err != nil && strings.Contains(err.Error(), "no such host") {
-						verified = true
-						break
-					}
-					time.Sleep(time.Second)
-					elapsed = time.Since(start)
-				}
-				if !verified {
-					ValidationFailCount.WithLabelValues("delete").Inc()
-				} else {
-					ValidationDuration.WithLabelValues("delete").Observe(elapsed.Seconds())
+	// add read load
+	if backgroundListInterval > 0 {
+		go backgroundList(kapi, backgroundListInterval)
+	}
+
+	// build up the cluster state to churn against
+	var populated []standingService
+	if prepopulateCount > 0 {
+		var interrupted bool
+		if populated, interrupted = prepopulate(kapi, prepopulateCount, sig); interrupted {
+			// exit through the cleanup of the main loop
+			sig <- syscall.SIGTERM
+		}
+	}
+
+	// sample steady state latency
+	if standingCount > 0 {
+		if standing := createStanding(kapi, standingCount); len(standing) > 0 {
+			go sampleStanding(standing, standingInterval)
+		}
+	}
+
+	if maxInflight > 0 {
+		startWorkers(maxInflight, inflightQueue)
+	}
+
+	if shardGroup != "" {
+		startSharding(kapi)
+	}
+	if otlpEndpoint != "" {
+		if err := setupTracing(); err != nil {
+			log.Fatalf("could not export traces to %v: %v", otlpEndpoint, err)
+		}
+	}
+
+	// start ops ticker
+	rate, varying := profileOps(0)
+	ticker := newOpsTicker(rate)
+	defer ticker.Stop()
+
+	ticks := ticker.C()
+	if observeSelector != "" {
+		// observe existing services rather than churn
+		ticks = nil
+		go observeServices(kapi, observeInterval)
+		logEvent(logFields{action: "observe", object: "service", namespace: namespace}, "Observing services %v in %v every %v", observeSelector, namespace, observeInterval)
+	} else if workloads != nil {
+		// each workload ticks at its own rate
+		ticks = nil
+		total := 0.0
+		for _, w := range workloads {
+			total += w.ops
+			go runWorkload(kapi, w)
+		}
+		setCurrentOps(total)
+		interval, _ := tickInterval(lowestOps())
+		setTickInterval(interval)
+	} else if profile == "ramp" {
+		logEvent(logFields{action: "rate"}, "Ramping from %v to %v operations per second over %v", rampFrom, rampTo, rampDuration)
+	} else if profile == "burst" {
+		logEvent(logFields{action: "rate"}, "Performing %v operations per second, bursting to %v for %v every %v", ops, burstOps, burstDuration, profilePeriod)
+	} else if profile == "sine" {
+		logEvent(logFields{action: "rate"}, "Performing %v±%v operations per second over a period of %v", ops, sineAmplitude, profilePeriod)
+	} else if profile == "poisson" {
+		logEvent(logFields{action: "rate"}, "Performing %v operations per second with Poisson arrivals", ops)
+		ticker.poisson()
+	} else {
+		logEvent(logFields{action: "rate"}, "Performing %v operations per second (%v per %v tick)", ops, ticker.batch, ticker.interval)
+	}
+	if runDuration > 0 {
+		// stop as if signalled once the run is over
+		go func() {
+			clock.Sleep(runDuration)
+			logEvent(logFields{action: "stop"}, "Ran for %v", runDuration)
+			sig <- syscall.SIGTERM
+		}()
+	}
+	if totalOps > 0 {
+		go func() {
+			<-opsBudgetDone
+			logEvent(logFields{action: "stop"}, "Performed %d operations", totalOps)
+			sig <- syscall.SIGTERM
+		}()
+	}
+	profileStart := clock.Now()
+	startedLooping()
+	go checkHealth(kapi, healthInterval)
+	for {
+		select {
+		case <-ticks:
+			recordTick()
+			if varying {
+				// the next tick follows at the new rate
+				rate, varying = profileOps(clock.Since(profileStart))
+				ticker.setRate(rate)
+				if !varying {
+					logEvent(logFields{action: "rate"}, "Ramp done, holding at %v operations per second (%v per %v tick)", rampTo, ticker.batch, ticker.interval)
 				}
-			}()
+			}
+			if profile == "poisson" {
+				ticker.poisson()
+			}
+			if errorBackoff.active() || namespaceRecreating() || opsPaused() {
+				continue
+			}
+			launchCycles(kapi, defaultWorkload, ticker.batch)
+		case rate = <-rateChanges:
+			// a rate set at runtime replaces the load profile
+			varying = false
+			ticker.setRate(rate)
+			logEvent(logFields{action: "rate"}, "Rate set to %v operations per second (%v per %v tick)", rate, ticker.batch, ticker.interval)
 		case <-sig:
-			log.Printf("Got signal, cleaning up and exiting...")
-			err = kapi.CoreV1().Pods(namespace).DeleteCollection(&metav1.DeleteOptions{}, metav1.ListOptions{LabelSelector: "kubernoisy=noise"})
-			if err != nil {
-				debugf("could not clean up pods %v", err)
+			logEvent(logFields{action: "stop"}, "Got signal, cleaning up and exiting...")
+			if drainTimeout > 0 {
+				ticker.Stop()
+				drain()
+			}
+			cancelOps()
+			// operations creating objects after the cleanup would leave them behind
+			if n := waitInflight(drainGrace); n > 0 {
+				logEvent(logFields{action: "stop"}, "%d operations still in flight, cleaning up anyway", n)
+			}
+			if len(populated) > 0 {
+				unpopulate(kapi, populated)
+			}
+			logEvent(logFields{action: "cleanup"}, "Cleaned up %d objects", reapObjects(kapi))
+			logSummary()
+			if junitReport != "" {
+				if err := writeJUnitReport(junitReport); err != nil {
+					logEvent(logFields{action: "report", err: err}, "could not write junit report %v: %v", junitReport, err)
+				}
+			}
+			if snapshotFile != "" {
+				writeSnapshot()
 			}
-			sl, err := kapi.CoreV1().Services(namespace).List(metav1.ListOptions{LabelSelector: "kubernoisy=noise"})
-			for _, s := range sl.Items {
-				err = kapi.CoreV1().Services(s.Namespace).Delete(s.Name, &metav1.DeleteOptions{})
-				if err != nil {
-					debugf("could not clean up service %v.%v: %v", s.Name, namespace, err)
+			if pusher != nil {
+				if err := pusher.Push(); err != nil {
+					logEvent(logFields{action: "push", err: err}, "could not push metrics to %v: %v", pushgateway, err)
 				}
 			}
-			os.Exit(0)
+			shutdownTracing()
+			code := finishReport()
+			shutdownServers(server, debugServer)
+			os.Exit(code)
 		}
 	}
 }
 
-func debugf(fmt string, v ...interface{}) {
-	if !verbose {
-		return
+// serve serves HTTP with server, exiting if it cannot, e.g. because its
+// address is in use.
+func serve(server *http.Server, name string) {
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("could not serve %v on %v: %v", name, server.Addr, err)
+	}
+}
+
+// shutdownServers gracefully shuts down the servers that are not nil, letting
+// requests in progress, e.g. a final scrape, complete for a few seconds.
+func shutdownServers(servers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, s := range servers {
+		if s == nil {
+			continue
+		}
+		if err := s.Shutdown(ctx); err != nil {
+			logEvent(logFields{action: "stop", err: err}, "could not shut down server on %v: %v", s.Addr, err)
+		}
 	}
-	log.Printf(fmt, v...)
 }
 
-func getAPIConn() (*kubernetes.Clientset, error) {
-	config, err := rest.InClusterConfig()
+func getAPIConn() (*rest.Config, *kubernetes.Clientset, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" || kubeContext != "" {
+		// an explicit kubeconfig targets its cluster even from within another
+		config, err = kubeconfigConfig()
+	} else if config, err = rest.InClusterConfig(); err == rest.ErrNotInCluster {
+		config, err = kubeconfigConfig()
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	config.ContentType = "application/vnd.kubernetes.protobuf"
+	if apiQPS > 0 {
+		config.QPS = float32(apiQPS)
+	}
+	if apiBurst > 0 {
+		config.Burst = apiBurst
+	}
+	kapi, err := kubernetes.NewForConfig(config)
+	return config, kapi, err
+}
 
-	return kubernetes.NewForConfig(config)
+// kubeconfigConfig returns the config of the kubeconfig context, defaulting the
+// cluster name to the cluster of the context.
+func kubeconfigConfig() (*rest.Config, error) {
+	rules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}
+	if kubeconfig == "" {
+		// $KUBECONFIG, a list of files merged as by kubectl, then ~/.kube/config
+		rules = clientcmd.NewDefaultClientConfigLoadingRules()
+	}
+	cc := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext})
+	config, err := cc.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if clusterName == "" {
+		raw, err := cc.RawConfig()
+		if err != nil {
+			return nil, err
+		}
+		name := kubeContext
+		if name == "" {
+			name = raw.CurrentContext
+		}
+		if ctx, ok := raw.Contexts[name]; ok {
+			clusterName = ctx.Cluster
+		}
+	}
+	logEvent(logFields{}, "Using kubeconfig %v", strings.Join(rules.GetLoadingPrecedence(), string(filepath.ListSeparator)))
+	return config, nil
 }
 
 func init() {
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gatherer gathers the metrics to serve, push and snapshot.
+var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+
+// A labelGatherer adds constant labels to all metrics of a gatherer, so that
+// they need not be known when the metrics are registered.
+type labelGatherer struct {
+	prometheus.Gatherer
+	labels []*dto.LabelPair
+}
+
+// newLabelGatherer returns a gatherer of g adding the labels.
+func newLabelGatherer(g prometheus.Gatherer, labels prometheus.Labels) *labelGatherer {
+	lg := &labelGatherer{Gatherer: g}
+	for name, value := range labels {
+		name, value := name, value
+		lg.labels = append(lg.labels, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	return lg
+}
+
+// Gather gathers the metrics of the wrapped gatherer, adding the labels.
+func (g *labelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			m.Label = append(m.Label, g.labels...)
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+	}
+	return families, err
+}
+
+var (
+	OperationCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "action_count_total",
+		Help:      "Counter of object actions",
+	}, []string{"object", "action"})
+
+	NamespaceOperationCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "namespace_action_count_total",
+		Help:      "Counter of object actions by namespace",
+	}, []string{"namespace", "object", "action"})
+
+	ValidationFailCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "validation_fail_count_total",
+		Help:      "Counter of validation failures, by reason: unverified, mismatch (answered, but not with the wanted addresses), dnssec, premature, or for removals servfail, timeout or error",
+	}, []string{"action", "type", "family", "reason", "namespace", "method"})
+
+	CycleFailCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "cycle_fail_count_total",
+		Help:      "Counter of cycles ended early by a failure, by phase",
+	}, []string{"phase"})
+
+	EndpointQuorumFraction = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "endpoint_quorum_fraction",
+		Buckets:   prometheus.LinearBuckets(0.1, 0.1, 10), // from 0.1 to 1
+		Help:      "Fraction of the expected addresses resolved when an add verification succeeded",
+	})
+
+	BatchSpread = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "batch_spread_seconds",
+		Buckets:   prometheus.LinearBuckets(0, 0.5, 20), // from 0s to 9.5 seconds
+		Help:      "Difference between the slowest and fastest add propagation delay within a batch",
+	})
+
+	DeleteTTLRatio = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "delete_ttl_ratio",
+		Buckets:   []float64{0.1, 0.25, 0.5, 0.75, 1, 1.25, 1.5, 2, 3, 5},
+		Help:      "Delete propagation delay as a fraction of the ttl of the deleted record",
+	})
+
+	DeleteTTLDifference = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "delete_ttl_difference_seconds",
+		Buckets:   prometheus.LinearBuckets(-30, 5, 13), // from -30s to 30 seconds
+		Help:      "Delete propagation delay minus the ttl of the deleted record",
+	})
+
+	DrainedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "drained_count_total",
+		Help:      "Counter of verifications in flight on exit, by result: verified, or abandoned at the drain timeout",
+	}, []string{"result"})
+
+	OrphansCleanedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "orphans_cleaned_total",
+		Help:      "Counter of objects deleted for being older than the object ttl",
+	})
+
+	OperationsSkipped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "operations_skipped_total",
+		Help:      "Counter of operations not started because the maximum operations were in flight",
+	})
+
+	DroppedOperations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "dropped_operations_total",
+		Help:      "Counter of operations dropped because every max-inflight worker was busy and the inflight queue full",
+	})
+
+	InflightOperations = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "inflight_operations",
+		Help:      "Operations currently running",
+	})
+
+	QueuedOperations = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "queued_operations",
+		Help:      "Operations waiting for one in flight to finish with max-inflight",
+	})
+
+	ErrorBackoff = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "error_backoff_seconds",
+		Help:      "Current delay before starting new operations after consecutive failed operations",
+	})
+
+	StaleAnswerCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "stale_answer_count_total",
+		Help:      "Counter of verifications answered with the address of a deleted object",
+	})
+
+	PodsPerNode = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "pods_per_node_total",
+		Help:      "Counter of verified pods scheduled on each node",
+	}, []string{"node"})
+
+	TTLIgnoredCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "ttl_ignored_count_total",
+		Help:      "Counter of lookups just past the ttl of a record that were no slower than the cached lookup",
+	})
+
+	UnstableVerifyCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "unstable_verify_count_total",
+		Help:      "Counter of records that resolved but did not stay present for the minimum verify duration",
+	})
+
+	QueryTimeoutCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "query_timeout_count_total",
+		Help:      "Counter of individual DNS lookups abandoned after the query timeout",
+	})
+
+	CreateRejectedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "create_rejected_count_total",
+		Help:      "Counter of creates rejected by the API server, e.g. by validation or admission, by reason",
+	}, []string{"resource", "reason"})
+
+	SSAConflictCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "ssa_conflict_count_total",
+		Help:      "Counter of server-side apply conflicts with another field manager",
+	}, []string{"resource"})
+
+	CoreDNSReloadCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "coredns_reload_total",
+		Help:      "Counter of changes to the watched DNS server config",
+	})
+
+	PreQueryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "pre_query_count_total",
+		Help:      "Counter of pre-verify queries by result: positive, negative or error",
+	}, []string{"result"})
+
+	ExtraAnswerCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "extra_answer_count_total",
+		Help:      "Counter of answered addresses not belonging to the verified object",
+	})
+
+	PodZoneDisagreementCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "pod_zone_disagreement_count_total",
+		Help:      "Counter of service endpoint addresses whose pod record does not resolve to the same address",
+	})
+
+	TickBatchSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "tick_batch_size",
+		Help:      "Operations started per tick",
+	})
+
+	RetryBudgetRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "retry_budget_remaining",
+		Help:      "Retries remaining of the retry budget of the whole run",
+	})
+
+	RetryBudgetExhaustedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "retry_budget_exhausted_total",
+		Help:      "Counter of retries not made because the retry budget was exhausted",
+	})
+
+	CreateRetryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "create_retries_total",
+		Help:      "Counter of retries of object creates that failed transiently, by resource",
+	}, []string{"resource"})
+
+	DeleteLookupErrorCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "delete_lookup_errors_total",
+		Help:      "Counter of lookups verifying the removal of a record answered with an error other than not found, by error: servfail, timeout or error",
+	}, []string{"error"})
+
+	ChaosCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "chaos_actions_total",
+		Help:      "Counter of chaos actions, by action and result: create-order and delete-order by the order swapped to, collision rejected, accepted or error, recreate and abandon done or error",
+	}, []string{"action", "result"})
+
+	RouteAdmitFailCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "route_admit_failures_total",
+		Help:      "Counter of Ingresses and HTTPRoutes not admitted by a controller within the timeout, by object",
+	}, []string{"object"})
+
+	ConnectFailCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "connect_failures_total",
+		Help:      "Counter of answers of a service not reachable on the container port within the timeout, by mode and reason: refused, timeout, http-status or error",
+	}, []string{"mode", "reason"})
+
+	DeleteRetryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "delete_retries_total",
+		Help:      "Counter of retries of object deletes that failed transiently, by resource",
+	}, []string{"resource"})
+
+	SRVFieldMismatchCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "srv_field_mismatch_count_total",
+		Help:      "Counter of SRV records answered with an unexpected field, by field: priority or weight",
+	}, []string{"field"})
+
+	SRVPortValidationCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "srv_port_validation_count_total",
+		Help:      "Counter of service SRV record verifications by port name and result",
+	}, []string{"port", "result"})
+
+	PrepopulatedServices = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "prepopulated_services",
+		Help:      "Services created before the operations started, to be deleted at the end",
+	})
+
+	StandingServices = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "standing_services",
+		Help:      "Services of the standing population",
+	})
+
+	WorkloadOps = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "workload_ops",
+		Help:      "Operations per second started by each workload of the config or of the ops of each object",
+	}, []string{"workload", "object"})
+
+	WorkloadOperationCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "workload_operations_total",
+		Help:      "Counter of operations done, by workload, object and result: success or failure",
+	}, []string{"workload", "object", "result"})
+
+	APIHealthy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "api_healthy",
+		Help:      "Whether the API server answered the last health check",
+	})
+
+	KeepUpRatio = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "keep_up_ratio",
+		Help:      "Fraction of the operations due at the ticks of the last health interval that were started",
+	})
+
+	ValidationFailStreak = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "validation_fail_streak",
+		Help:      "Operations failing verification in a row",
+	})
+
+	CurrentOps = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "current_ops",
+		Help:      "Operations per second currently started",
+	})
+
+	IPCollisionCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "ip_collision_count_total",
+		Help:      "Counter of addresses resolved for an object that another live object also resolves to",
+	})
+
+	LastConvergedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "last_converged_count_total",
+		Help:      "Counter of verifications in which each required resolver was the last to converge",
+	}, []string{"resolver"})
+
+	VerifySampleRate = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "verify_sample_rate",
+		Help:      "Fraction of operations verified in DNS",
+	})
+
+	ObservedAvailable = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "observed_service_available",
+		Help:      "Whether an observed service resolved on its last verification",
+	}, []string{"service"})
+
+	LookupFuncInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kubernoisy",
+		Name:      "lookup_func_info",
+		Help:      "Lookup function used to resolve addresses, always 1",
+	}, []string{"func"})
+
+	ZoneAnswerCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "zone_answer_count_total",
+		Help:      "Counter of answered endpoints by client zone and endpoint zone",
+	}, []string{"client_zone", "answer_zone"})
+
+	ZoneLocalityFailCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernoisy",
+		Name:      "zone_locality_fail_count_total",
+		Help:      "Counter of answers including non-local endpoints while zone-local endpoints existed",
+	})
+)
+
+// Latency histograms, registered by registerLatencyMetrics once the flags are
+// parsed, as their buckets depend on -native-histograms.
+var (
+	ValidationDuration         *prometheus.HistogramVec
+	CreateOrderDuration        *prometheus.HistogramVec
+	ScaleUpEndpointDuration    prometheus.Histogram
+	BatchDuration              prometheus.Histogram
+	RecreateDuration           *prometheus.HistogramVec
+	NamespaceDeleteDuration    prometheus.Histogram
+	NamespaceRecoveryDuration  prometheus.Histogram
+	EndpointsReadyDuration     *prometheus.HistogramVec
+	DNSAfterEndpointsDuration  prometheus.Histogram
+	OrdinalValidationDuration  *prometheus.HistogramVec
+	NodeValidationDuration     *prometheus.HistogramVec
+	TTLLookupDuration          *prometheus.HistogramVec
+	DNSQueryDuration           *prometheus.HistogramVec
+	ObservedLookupDuration     *prometheus.HistogramVec
+	StaleAnswerDuration        *prometheus.HistogramVec
+	PartialPropagationDuration *prometheus.HistogramVec
+	StaleServingDuration       *prometheus.HistogramVec
+	ConnectDuration            *prometheus.HistogramVec
+	RouteAdmitDuration         *prometheus.HistogramVec
+	DNSSECValidationDuration   *prometheus.HistogramVec
+	ResolverValidationDuration *prometheus.HistogramVec
+	ResolverSkewDuration       prometheus.Histogram
+	StandingLookupDuration     prometheus.Histogram
+	ListDuration               *prometheus.HistogramVec
+)
+
+// nativeHistogramBucketFactor is the growth factor of native histogram buckets,
+// giving about 8 buckets per doubling of the latency.
+const nativeHistogramBucketFactor = 1.1
+
+// latencyOpts returns opts with native buckets, in addition to the classic
+// buckets, if native histograms are enabled.
+func latencyOpts(opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	if nativeHistograms {
+		opts.NativeHistogramBucketFactor = nativeHistogramBucketFactor
+	}
+	return opts
+}
+
+// validationBuckets returns the buckets of the validation latency histograms
+// per the bucket flags: count linear buckets of width from start, or count
+// exponential buckets growing by width from start.
+func validationBuckets() []float64 {
+	if bucketLayout == "exponential" {
+		return prometheus.ExponentialBuckets(bucketStart, bucketWidth, bucketCount)
+	}
+	return prometheus.LinearBuckets(bucketStart, bucketWidth, bucketCount)
+}
+
+// checkBuckets returns an error if the bucket flags do not make a layout.
+func checkBuckets() error {
+	switch {
+	case bucketLayout != "linear" && bucketLayout != "exponential":
+		return fmt.Errorf("unknown buckets %q", bucketLayout)
+	case bucketCount < 1:
+		return fmt.Errorf("bucket-count must be >= 1")
+	case bucketLayout == "linear" && bucketWidth <= 0:
+		return fmt.Errorf("bucket-width must be > 0")
+	case bucketLayout == "exponential" && (bucketStart <= 0 || bucketWidth <= 1):
+		return fmt.Errorf("exponential buckets need a bucket-start > 0 and a bucket-width (factor) > 1")
+	}
+	return nil
+}
+
+// registerLatencyMetrics creates and registers the latency histograms.
+func registerLatencyMetrics() {
+	ValidationDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "validation_duration_seconds",
+		Buckets:   validationBuckets(),
+		Help:      "Delay to reflect in DNS record",
+	}), []string{"action", "type", "family", "namespace", "method"})
+
+	CreateOrderDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "create_order_duration_seconds",
+		Buckets:   prometheus.LinearBuckets(0, 1, 30), // from 0s to 29 seconds
+		Help:      "Delay for a service to reflect in DNS, by the order its pods and service were created in",
+	}), []string{"order"})
+
+	ScaleUpEndpointDuration = promauto.NewHistogram(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "scale_up_endpoint_duration_seconds",
+		Buckets:   prometheus.LinearBuckets(0, 1, 30), // from 0s to 29 seconds
+		Help:      "Delay from scaling up a deployment to each added endpoint appearing in DNS",
+	}))
+
+	BatchDuration = promauto.NewHistogram(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "batch_duration_seconds",
+		Buckets:   prometheus.LinearBuckets(0, 2, 30), // from 0s to 58 seconds
+		Help:      "Duration of a batch of concurrent operations, from creation to verified deletion of all objects",
+	}))
+
+	RecreateDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "recreate_duration_seconds",
+		Buckets:   prometheus.LinearBuckets(0, 1, 30), // from 0s to 29 seconds
+		Help:      "Delay for DNS to follow a service recreated under the same name to its new cluster ip",
+	}), []string{"ip_changed"})
+
+	NamespaceDeleteDuration = promauto.NewHistogram(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "namespace_delete_duration_seconds",
+		Buckets:   prometheus.LinearBuckets(0, 5, 24), // from 0s to 115 seconds
+		Help:      "Duration from deleting the namespace to it being removed",
+	}))
+
+	NamespaceRecoveryDuration = promauto.NewHistogram(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "namespace_recovery_duration_seconds",
+		Buckets:   prometheus.LinearBuckets(0, 1, 30), // from 0s to 29 seconds
+		Help:      "Duration from recreating the namespace to a service in it resolving",
+	}))
+
+	EndpointsReadyDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "endpoints_ready_duration_seconds",
+		Buckets:   prometheus.LinearBuckets(0, 1, 30), // from 0s to 29 seconds
+		Help:      "Duration from creating a service and its pods to the watched endpoints having a ready address for each pod",
+	}), []string{"api"})
+
+	DNSAfterEndpointsDuration = promauto.NewHistogram(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "dns_after_endpoints_duration_seconds",
+		Buckets:   prometheus.LinearBuckets(0, 1, 30), // from 0s to 29 seconds
+		Help:      "Duration from the watched endpoints of a service having a ready address for each pod to the service resolving",
+	}))
+
+	OrdinalValidationDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "ordinal_validation_duration_seconds",
+		Buckets:   validationBuckets(),
+		Help:      "Delay to reflect the hostname record of a statefulset pod in DNS, by action and ordinal",
+	}), []string{"action", "ordinal"})
+
+	NodeValidationDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "node_validation_duration_seconds",
+		Buckets:   validationBuckets(),
+		Help:      "Delay to reflect the record of a single pod in DNS, by the node of the pod",
+	}), []string{"node"})
+
+	TTLLookupDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "ttl_lookup_duration_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14), // from 0.5ms to 4 seconds
+		Help:      "Duration of looking up a cached record, and of looking it up again just past its ttl",
+	}), []string{"cache"})
+
+	DNSQueryDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "dns_query_duration_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14), // from 0.5ms to 4 seconds
+		Help:      "Duration of each DNS lookup of a verification, by action and outcome: hit, miss or error",
+	}), []string{"action", "outcome"})
+
+	ObservedLookupDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "observed_lookup_duration_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14), // from 0.5ms to 4 seconds
+		Help:      "Duration of resolving an observed service",
+	}), []string{"service"})
+
+	StaleServingDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "stale_serving_duration_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12), // from 100ms to 3.4 minutes
+		Help:      "Duration for which a deleted record was still answered with records, by action",
+	}), []string{"action"})
+
+	ConnectDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "connect_duration_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14), // from 1ms to 8 seconds
+		Help:      "Latency of a successful TCP connect or HTTP GET to an answer of a service on the container port, by mode",
+	}), []string{"mode"})
+
+	RouteAdmitDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "route_admit_duration_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12), // from 100ms to 3.4 minutes
+		Help:      "Delay until a created Ingress got a load balancer address, or an HTTPRoute was accepted by its gateway, by object",
+	}), []string{"object"})
+
+	PartialPropagationDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "partial_propagation_duration_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15), // from 10ms to 2.7 minutes
+		Help:      "Delay from a verification first answered with any of several expected addresses to all of them, by action",
+	}), []string{"action"})
+
+	StaleAnswerDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "stale_answer_duration_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12), // from 100ms to 3.4 minutes
+		Help:      "Duration for which a verification was answered with the address of a deleted object, by action",
+	}), []string{"action"})
+
+	ResolverValidationDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "resolver_validation_duration_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 20), // from 1ms to about 9 minutes
+		Help:      "Add propagation delay of a record to each of the required resolvers",
+	}), []string{"resolver"})
+
+	ResolverSkewDuration = promauto.NewHistogram(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "resolver_skew_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 20), // from 1ms to about 9 minutes
+		Help:      "Skew of the add propagation delay of a record between the fastest and slowest of the required resolvers",
+	}))
+
+	DNSSECValidationDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "dnssec_validation_duration_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14), // from 0.5ms to 4 seconds
+		Help:      "Duration of validating the DNSSEC signatures of a verified record, by result: valid or invalid",
+	}), []string{"result"})
+
+	StandingLookupDuration = promauto.NewHistogram(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "standing_lookup_duration_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14), // from 0.5ms to 4 seconds
+		Help:      "Duration of resolving a service of the standing population",
+	}))
+
+	ListDuration = promauto.NewHistogramVec(latencyOpts(prometheus.HistogramOpts{
+		Namespace: "kubernoisy",
+		Name:      "list_duration_seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12), // from 5ms to 10 seconds
+		Help:      "Duration of background list requests",
+	}), []string{"object"})
+}
+
+// recordOperation counts an action on an object in the namespace ns.
+func recordOperation(ns, object, action string) {
+	OperationCount.WithLabelValues(object, action).Inc()
+	NamespaceOperationCount.WithLabelValues(ns, object, action).Inc()
+}
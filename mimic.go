@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// mimicResolver resolves names the way a given pod does, expanding them with
+// the search path and ndots of its resolv.conf and querying its nameservers in
+// order, without running inside it.
+type mimicResolver struct {
+	servers []string
+	search  []string
+	ndots   int
+
+	resolvers []*net.Resolver
+}
+
+// newMimicResolver returns a mimicResolver replicating the resolv.conf of the
+// pod ref, given as "namespace/name" or just "name" in the operating namespace.
+func newMimicResolver(config *rest.Config, kapi kubernetes.Interface, ref string) (*mimicResolver, error) {
+	pod, err := newExecResolver(config, kapi, ref)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := pod.exec("cat", "/etc/resolv.conf")
+	if err != nil {
+		return nil, fmt.Errorf("could not read resolv.conf of pod %v.%v: %v", pod.name, pod.namespace, err)
+	}
+	m := &mimicResolver{servers: parseNameservers(conf), ndots: 1}
+	s := bufio.NewScanner(strings.NewReader(conf))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 2 {
+			continue
+		}
+		switch f[0] {
+		case "search":
+			m.search = f[1:]
+		case "options":
+			for _, o := range f[1:] {
+				if strings.HasPrefix(o, "ndots:") {
+					if n, err := strconv.Atoi(strings.TrimPrefix(o, "ndots:")); err == nil {
+						m.ndots = n
+					}
+				}
+			}
+		}
+	}
+	if len(m.servers) == 0 {
+		return nil, fmt.Errorf("no nameserver in resolv.conf of pod %v.%v", pod.name, pod.namespace)
+	}
+	for _, server := range m.servers {
+		m.resolvers = append(m.resolvers, newResolver(net.JoinHostPort(server, "53")))
+	}
+	return m, nil
+}
+
+// String returns the mimicked config.
+func (m *mimicResolver) String() string {
+	return fmt.Sprintf("nameservers %v, search %v, ndots %d", m.servers, m.search, m.ndots)
+}
+
+// candidates returns the fully qualified names tried for name, in order: name
+// itself first if it has at least ndots dots, then name in each search domain.
+func (m *mimicResolver) candidates(name string) []string {
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+	var names []string
+	for _, domain := range m.search {
+		names = append(names, name+"."+strings.TrimSuffix(domain, ".")+".")
+	}
+	if strings.Count(name, ".") >= m.ndots {
+		return append([]string{name + "."}, names...)
+	}
+	return append(names, name+".")
+}
+
+// resolve calls lookup with each candidate name of name until one exists,
+// trying the next nameserver when one fails to answer. It returns a "no such
+// host" error if none of the candidates exist.
+func (m *mimicResolver) resolve(name string, lookup func(r *net.Resolver, fqdn string) error) error {
+	for _, fqdn := range m.candidates(name) {
+		var err error
+		for _, r := range m.resolvers {
+			if err = lookup(r, fqdn); err == nil || notFound(err) {
+				break
+			}
+		}
+		if !notFound(err) {
+			return err
+		}
+	}
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// notFound returns true if err reports that a name does not exist.
+func notFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.IsNotFound
+}
+
+// LookupIPAddr resolves host to addresses as the mimicked pod does.
+func (m *mimicResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+	err := m.resolve(host, func(r *net.Resolver, fqdn string) (err error) {
+		addrs, err = r.LookupIPAddr(ctx, fqdn)
+		return err
+	})
+	return addrs, err
+}
+
+// LookupHost resolves host to address strings as the mimicked pod does.
+func (m *mimicResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	var hosts []string
+	err := m.resolve(host, func(r *net.Resolver, fqdn string) (err error) {
+		hosts, err = r.LookupHost(ctx, fqdn)
+		return err
+	})
+	return hosts, err
+}
+
+// LookupSRV resolves the SRV records of _service._proto.name as the mimicked
+// pod does.
+func (m *mimicResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	var cname string
+	var srvs []*net.SRV
+	err := m.resolve("_"+service+"._"+proto+"."+name, func(r *net.Resolver, fqdn string) (err error) {
+		cname, srvs, err = r.LookupSRV(ctx, "", "", fqdn)
+		return err
+	})
+	return cname, srvs, err
+}
+
+// LookupAddr resolves the PTR records of addr on the nameservers of the
+// mimicked pod, which do not involve the search path.
+func (m *mimicResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	var names []string
+	var err error
+	for _, r := range m.resolvers {
+		names, err = r.LookupAddr(ctx, addr)
+		if err == nil || notFound(err) {
+			break
+		}
+	}
+	return names, err
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// namespaceDown is set while the namespace is being recreated.
+var namespaceDown int32
+
+// namespaces are the namespaces to operate in, parsed from the namespace
+// flag, and namespacePick counts the namespaces picked round-robin. With
+// namespace churn, namespaceMu guards namespaces, and retiring are the
+// namespaces rotated out, until deleted.
+var (
+	namespaces    []string
+	namespacePick uint64
+	namespaceMu   sync.RWMutex
+	retiring      []string
+)
+
+// namespaceNext counts the namespaces of the namespace count created, the
+// first namespace-count on start and then one per rotation.
+var namespaceNext int
+
+// countedNamespaces returns the namespace count namespaces prefixed by prefix.
+func countedNamespaces(prefix string, n int) []string {
+	nss := make([]string, n)
+	for i := range nss {
+		nss[i] = countedNamespace(prefix, i)
+	}
+	return nss
+}
+
+// countedNamespace returns the i'th name of the namespaces prefixed by prefix.
+// Rotations reuse the names of twice the namespace count, those rotated out
+// long enough ago to be deleted, bounding the namespaces of the metrics.
+func countedNamespace(prefix string, i int) string {
+	return fmt.Sprintf("%v-%d", prefix, i%(2*namespaceCount))
+}
+
+// parseNamespaces parses a comma separated list of distinct namespaces.
+func parseNamespaces(s string) ([]string, error) {
+	var nss []string
+	seen := make(map[string]bool)
+	for _, ns := range strings.Split(s, ",") {
+		ns = strings.TrimSpace(ns)
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid namespace %q: %v", ns, strings.Join(errs, "; "))
+		}
+		if seen[ns] {
+			return nil, fmt.Errorf("namespace %q is listed twice", ns)
+		}
+		seen[ns] = true
+		nss = append(nss, ns)
+	}
+	return nss, nil
+}
+
+// pickNamespace returns the namespace for a new cycle, in turn or at random
+// per the namespace order.
+func pickNamespace() string {
+	namespaceMu.RLock()
+	defer namespaceMu.RUnlock()
+	if namespaceOrder == "random" {
+		return namespaces[rand.Intn(len(namespaces))]
+	}
+	return namespaces[(atomic.AddUint64(&namespacePick, 1)-1)%uint64(len(namespaces))]
+}
+
+// activeNamespaces returns the namespaces new cycles are started in.
+func activeNamespaces() []string {
+	namespaceMu.RLock()
+	defer namespaceMu.RUnlock()
+	return append([]string(nil), namespaces...)
+}
+
+// allNamespaces returns the namespaces objects may be in: those new cycles are
+// started in and those rotated out but not yet deleted.
+func allNamespaces() []string {
+	namespaceMu.RLock()
+	defer namespaceMu.RUnlock()
+	return append(append([]string(nil), namespaces...), retiring...)
+}
+
+// churnNamespaces rotates the namespaces at every interval until shutdown.
+func churnNamespaces(kapi kubernetes.Interface, prefix string, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		if draining() || opsCtx.Err() != nil {
+			return
+		}
+		if err := rotateNamespace(kapi, prefix); err != nil {
+			logEvent(logFields{action: "rotate", object: "namespace", err: err}, "could not rotate namespaces: %v", err)
+		}
+	}
+}
+
+// rotateNamespace creates the next namespace and starts new cycles in it
+// rather than in the oldest, which is deleted once its cycles are done.
+func rotateNamespace(kapi kubernetes.Interface, prefix string) error {
+	next := countedNamespace(prefix, namespaceNext)
+	ctx, cancel := apiContext()
+	defer cancel()
+	_, err := kapi.CoreV1().Namespaces().Create(ctx, newNamespace(next), metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		// retried at the next rotation
+		return fmt.Errorf("namespace %v is still being deleted", next)
+	}
+	if err != nil {
+		return err
+	}
+	namespaceNext++
+	OperationCount.WithLabelValues("namespace", "add").Inc()
+
+	namespaceMu.Lock()
+	old := namespaces[0]
+	namespaces = append(namespaces[1:], next)
+	retiring = append(retiring, old)
+	namespaceMu.Unlock()
+	logEvent(logFields{action: "add", object: "namespace", name: next}, "Rotated namespace %v out for %v", old, next)
+	go retireNamespace(kapi, old)
+	return nil
+}
+
+// retireNamespace deletes the namespace ns rotated out, after twice the
+// longest timeout, by when the cycles started in it are done.
+func retireNamespace(kapi kubernetes.Interface, ns string) {
+	var grace time.Duration
+	for _, w := range allWorkloads() {
+		if 2*w.timeout > grace {
+			grace = 2 * w.timeout
+		}
+	}
+	for start := clock.Now(); clock.Since(start) < grace && !abandoned(); {
+		clock.Sleep(time.Second)
+	}
+	if abandoned() {
+		// left to the cleanup on exit
+		return
+	}
+	ctx, cancel := apiContext()
+	defer cancel()
+	err := kapi.CoreV1().Namespaces().Delete(ctx, ns, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		logEvent(logFields{action: "delete", object: "namespace", name: ns, err: err}, "could not delete namespace %v: %v", ns, err)
+	} else {
+		OperationCount.WithLabelValues("namespace", "delete").Inc()
+		logEvent(logFields{action: "delete", object: "namespace", name: ns}, "Deleted namespace %v", ns)
+	}
+	namespaceMu.Lock()
+	for i, r := range retiring {
+		if r == ns {
+			retiring = append(retiring[:i], retiring[i+1:]...)
+			break
+		}
+	}
+	namespaceMu.Unlock()
+}
+
+// namespaceRecreating returns true if new operations should not be started
+// because the namespace is being recreated.
+func namespaceRecreating() bool {
+	return atomic.LoadInt32(&namespaceDown) == 1
+}
+
+// keyValues is a repeatable flag of key=value pairs.
+type keyValues map[string]string
+
+// String returns the pairs as comma separated key=value, sorted by key.
+func (kv keyValues) String() string {
+	pairs := make([]string, 0, len(kv))
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set adds a key=value pair.
+func (kv keyValues) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("%q is not key=value", s)
+	}
+	kv[s[:i]] = s[i+1:]
+	return nil
+}
+
+// validateNamespaceMeta returns an error if the namespace labels or
+// annotations are not valid.
+func validateNamespaceMeta() error {
+	for k, v := range namespaceLabels {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace-label key %q: %v", k, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace-label value %q: %v", v, strings.Join(errs, "; "))
+		}
+	}
+	for k := range namespaceAnnotations {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace-annotation key %q: %v", k, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// newNamespace returns the namespace ns with the namespace labels and annotations.
+func newNamespace(ns string) *v1.Namespace {
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        ns,
+		Labels:      namespaceLabels,
+		Annotations: namespaceAnnotations,
+	}}
+}
+
+// createNamespace creates the namespace ns unless it already exists.
+func createNamespace(kapi kubernetes.Interface, ns string) error {
+	ctx, cancel := apiContext()
+	defer cancel()
+	_, err := kapi.CoreV1().Namespaces().Create(ctx, newNamespace(ns), metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		logEvent(logFields{object: "namespace", name: ns}, "Namespace %v already exists", ns)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	OperationCount.WithLabelValues("namespace", "add").Inc()
+	logEvent(logFields{action: "add", object: "namespace", name: ns}, "Created namespace %v", ns)
+	return nil
+}
+
+// ownNamespace returns the namespace kubernoisy runs in, if in cluster.
+func ownNamespace() string {
+	b, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// recreateNamespace deletes and recreates the namespace at every interval,
+// with the objects in it, and records the time for a new service in it to
+// resolve once recreated.
+func recreateNamespace(kapi kubernetes.Interface, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		atomic.StoreInt32(&namespaceDown, 1)
+		err := cycleNamespace(kapi)
+		atomic.StoreInt32(&namespaceDown, 0)
+		if err != nil {
+			logEvent(logFields{action: "add", object: "namespace", name: namespace, err: err}, "could not recreate namespace %v: %v", namespace, err)
+		}
+	}
+}
+
+// cycleNamespace deletes the namespace, waits for it to be removed, creates it
+// again and verifies that a service created in it resolves.
+func cycleNamespace(kapi kubernetes.Interface) error {
+	start := clock.Now()
+	ctx, cancel := apiContext()
+	err := kapi.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
+	cancel()
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	OperationCount.WithLabelValues("namespace", "delete").Inc()
+	if err := waitNamespaceDeleted(kapi); err != nil {
+		return err
+	}
+	NamespaceDeleteDuration.Observe(clock.Since(start).Seconds())
+
+	ctx, cancel = apiContext()
+	_, err = kapi.CoreV1().Namespaces().Create(ctx, newNamespace(namespace), metav1.CreateOptions{})
+	cancel()
+	if err != nil {
+		return err
+	}
+	OperationCount.WithLabelValues("namespace", "add").Inc()
+	start = clock.Now()
+
+	// generate unique name
+	rando := namePrefix + RandStringBytes(18)
+	defer startTrace(rando)()
+	defer func() {
+		deletePods(kapi, namespace, rando)
+		deleteService(kapi, namespace, rando)
+	}()
+	if !createPods(kapi, namespace, rando) || !createService(kapi, namespace, rando) {
+		return fmt.Errorf("could not create service %v", rando)
+	}
+	results := verifyQueries("namespace-recreate", []query{{rtype: "IP", name: serviceHost(namespace, rando)}}, true)
+	if !results[0].verified {
+		return fmt.Errorf("service %v did not resolve", rando)
+	}
+	NamespaceRecoveryDuration.Observe(clock.Since(start).Seconds())
+	return nil
+}
+
+// waitNamespaceDeleted polls the namespace until it is removed, up to the timeout.
+func waitNamespaceDeleted(kapi kubernetes.Interface) error {
+	for start := clock.Now(); clock.Since(start) < timeout; clock.Sleep(time.Second) {
+		ctx, cancel := apiContext()
+		_, err := kapi.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		cancel()
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("timed out waiting for deletion")
+}
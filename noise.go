@@ -0,0 +1,100 @@
+package main
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// A noiseKind is a kind of object without DNS records, churned only for the
+// write and watch load it puts on the API server and etcd.
+type noiseKind struct {
+	object   string
+	resource string
+}
+
+var (
+	configMapKind = noiseKind{object: "configmap", resource: "configmaps"}
+	secretKind    = noiseKind{object: "secret", resource: "secrets"}
+)
+
+// write creates the object of k name with a new payload of the noise size, or
+// if update, replaces the payload of the existing object.
+func (k noiseKind) write(kapi kubernetes.Interface, ns, name string, update bool) error {
+	meta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: ns,
+		Labels:    workloadLabels(name, map[string]string{"kubernoisy": "noise"}),
+	}
+	payload := RandStringBytes(noiseSize)
+	var err error
+	ctx, cancel := apiContext()
+	defer cancel()
+	if k == secretKind {
+		s := &v1.Secret{ObjectMeta: meta, Data: map[string][]byte{"noise": []byte(payload)}}
+		if update {
+			_, err = kapi.CoreV1().Secrets(ns).Update(ctx, s, metav1.UpdateOptions{})
+		} else {
+			_, err = kapi.CoreV1().Secrets(ns).Create(ctx, s, metav1.CreateOptions{})
+		}
+		return err
+	}
+	cm := &v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{"noise": payload}}
+	if update {
+		_, err = kapi.CoreV1().ConfigMaps(ns).Update(ctx, cm, metav1.UpdateOptions{})
+	} else {
+		_, err = kapi.CoreV1().ConfigMaps(ns).Create(ctx, cm, metav1.CreateOptions{})
+	}
+	return err
+}
+
+// delete deletes the object of k name.
+func (k noiseKind) delete(kapi kubernetes.Interface, ns, name string) error {
+	ctx, cancel := apiContext()
+	defer cancel()
+	if k == secretKind {
+		return kapi.CoreV1().Secrets(ns).Delete(ctx, name, metav1.DeleteOptions{})
+	}
+	return kapi.CoreV1().ConfigMaps(ns).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+// noiseCycle returns the cycle of the kind k: it creates an object of k with a
+// payload of the noise size, replaces its payload noise updates times, then
+// deletes it. These objects have no DNS records, so there is nothing to
+// verify; the cycle only adds write load on the API server and etcd, and watch
+// traffic to the controllers watching them, to measure its effect on the DNS
+// of the other objects.
+func noiseCycle(k noiseKind) func(kapi kubernetes.Interface, w *workload, b *batch) bool {
+	return func(kapi kubernetes.Interface, w *workload, b *batch) bool {
+		// generate unique name
+		rando := namePrefix + RandStringBytes(18)
+		defer startTrace(rando)()
+		defer bindWorkload(rando, w)()
+		ns := pickNamespace()
+
+		if err := k.write(kapi, ns, rando, false); err != nil {
+			recordCreateRejected(k.resource, err)
+			logEvent(logFields{action: "add", object: k.object, name: rando, namespace: ns, err: err}, "could not create %v %v.%v: %v", k.object, rando, ns, err)
+			return failCycle("create", nil)
+		}
+		recordOperation(ns, k.object, "add")
+
+		cleanup := func() {
+			k.delete(kapi, ns, rando)
+		}
+		for i := 0; i < noiseUpdates; i++ {
+			if err := k.write(kapi, ns, rando, true); err != nil {
+				logEvent(logFields{action: "update", object: k.object, name: rando, namespace: ns, err: err}, "could not update %v %v.%v: %v", k.object, rando, ns, err)
+				return failCycle("update", cleanup)
+			}
+			recordOperation(ns, k.object, "update")
+		}
+
+		if err := k.delete(kapi, ns, rando); err != nil {
+			debugEvent(logFields{action: "delete", object: k.object, name: rando, namespace: ns, err: err}, "could not delete %v %v.%v: %v", k.object, rando, ns, err)
+			return failCycle("delete", nil)
+		}
+		recordOperation(ns, k.object, "delete")
+		return true
+	}
+}
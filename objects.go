@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podName returns the name of the i'th pod backing the service name.
+func podName(name string, i int) string {
+	if replicas == 1 {
+		return name
+	}
+	return fmt.Sprintf("%v-%d", name, i)
+}
+
+// newPod returns the i'th pod selected by the service name, of the pod
+// template if any.
+func newPod(ns, name string, i int) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName(name, i),
+			Namespace: ns,
+			Labels:    workloadLabels(name, map[string]string{"app": name, "kubernoisy": "noise"}),
+		},
+		Spec: podSpec(name),
+	}
+	pod.Spec.Hostname = "pod"
+	if node := pickNode(); node != "" {
+		pod.Spec.NodeName = node
+	}
+	if readinessGate {
+		pod.Spec.ReadinessGates = append(pod.Spec.ReadinessGates, v1.PodReadinessGate{ConditionType: readinessGateCondition})
+	}
+	if topologyHints {
+		// spread the pods across zones so that each zone has local endpoints
+		pod.Spec.TopologySpreadConstraints = append(pod.Spec.TopologySpreadConstraints, v1.TopologySpreadConstraint{
+			MaxSkew:           1,
+			TopologyKey:       zoneLabel,
+			WhenUnsatisfiable: v1.ScheduleAnyway,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+		})
+	}
+	return pod
+}
+
+// imagePullSecrets returns the image pull secrets of pods, if any.
+func imagePullSecrets() []v1.LocalObjectReference {
+	if imagePullSecret == "" {
+		return nil
+	}
+	return []v1.LocalObjectReference{{Name: imagePullSecret}}
+}
+
+// newService returns a headless service selecting the pods of name, a
+// ClusterIP service for a clusterip workload, or an ExternalName service for an
+// externalname workload.
+func newService(ns, name string) *v1.Service {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    workloadLabels(name, map[string]string{"kubernoisy": "noise"}),
+		},
+		Spec: v1.ServiceSpec{
+			Ports:     servicePorts(),
+			ClusterIP: v1.ClusterIPNone,
+			Type:      v1.ServiceTypeClusterIP,
+			Selector:  map[string]string{"app": name},
+		},
+	}
+	w := workloadOf(name)
+	if w.object == "clusterip" {
+		// allocate a cluster ip
+		svc.Spec.ClusterIP = ""
+	}
+	if w.object == "externalname" {
+		// an alias of the external name, without endpoints
+		svc.Spec.Type = v1.ServiceTypeExternalName
+		svc.Spec.ClusterIP = ""
+		svc.Spec.ExternalName = externalNameTarget()
+		svc.Spec.Selector = nil
+	}
+	if w.object == "endpointslice" || w.object == "endpoints" {
+		// endpoints are written directly rather than selected
+		svc.Spec.Selector = nil
+	}
+	if topologyHints {
+		svc.Annotations = map[string]string{topologyHintsAnnotation: "auto"}
+	}
+	return svc
+}
+
+// maxExtraPorts is the most extra ports, whose names must be at most 15
+// characters long.
+const maxExtraPorts = 9999
+
+// A namedPort is a named port of the pods and services.
+type namedPort struct {
+	name string
+	port int
+}
+
+// namedPorts returns the named ports of the pods and services: kubernoisy on
+// the container port, then kubernoisy-1 and on, on the following ports, for
+// each extra port.
+func namedPorts() []namedPort {
+	ports := []namedPort{{name: "kubernoisy", port: containerPort}}
+	for i := 1; i <= extraPorts; i++ {
+		ports = append(ports, namedPort{name: fmt.Sprintf("kubernoisy-%d", i), port: containerPort + i})
+	}
+	return ports
+}
+
+// portNumber returns the number of the named port name, or 0 if none.
+func portNumber(name string) int {
+	for _, p := range namedPorts() {
+		if p.name == name {
+			return p.port
+		}
+	}
+	return 0
+}
+
+// containerPorts returns the named ports of the pod containers.
+func containerPorts() []v1.ContainerPort {
+	var ports []v1.ContainerPort
+	for _, p := range namedPorts() {
+		ports = append(ports, v1.ContainerPort{Name: p.name, ContainerPort: int32(p.port)})
+	}
+	return ports
+}
+
+// servicePorts returns the named ports of the services.
+func servicePorts() []v1.ServicePort {
+	var ports []v1.ServicePort
+	for _, p := range namedPorts() {
+		ports = append(ports, v1.ServicePort{Name: p.name, Port: int32(p.port)})
+	}
+	return ports
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// observeServices verifies that the existing services in the namespace
+// matching the observe selector resolve, at every interval, without creating
+// anything.
+func observeServices(kapi kubernetes.Interface, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	observed := make(map[string]bool)
+	for range ticker.C() {
+		ctx, cancel := apiContext()
+		sl, err := kapi.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{LabelSelector: observeSelector})
+		cancel()
+		if err != nil {
+			logEvent(logFields{action: "observe", object: "service", namespace: namespace, err: err}, "could not list services %v in %v: %v", observeSelector, namespace, err)
+			continue
+		}
+		current := make(map[string]bool)
+		var wg sync.WaitGroup
+		for _, s := range sl.Items {
+			current[s.Name] = true
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				defer acquireVerifySlot()()
+				observeService(name)
+			}(s.Name)
+		}
+		wg.Wait()
+
+		// forget services that no longer exist
+		for name := range observed {
+			if !current[name] {
+				ObservedAvailable.DeleteLabelValues(name)
+				ObservedLookupDuration.DeleteLabelValues(name)
+			}
+		}
+		observed = current
+	}
+}
+
+// observeService resolves the service name once, recording whether it is
+// available and the lookup latency.
+func observeService(name string) {
+	start := clock.Now()
+	answers, err := lookup(query{rtype: "IP", name: serviceFQDN(namespace, name)})
+	elapsed := clock.Since(start)
+	available := err == nil && len(answers) > 0
+	if !available {
+		ObservedAvailable.WithLabelValues(name).Set(0)
+		debugEvent(logFields{action: "observe", object: "service", name: name, namespace: namespace, err: err}, "observed service %v.%v did not resolve: %v", name, namespace, err)
+	} else {
+		ObservedAvailable.WithLabelValues(name).Set(1)
+		recordSuccess()
+		ObservedLookupDuration.WithLabelValues(name).Observe(elapsed.Seconds())
+	}
+	recordValidation("observe", namespace, "IP", available, elapsed)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// podTemplate is the pod spec of the pod template file, if any.
+var podTemplate *v1.PodSpec
+
+// loadPodTemplate returns the YAML PodSpec of the file at path.
+func loadPodTemplate(path string) (*v1.PodSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec v1.PodSpec
+	if err := yaml.UnmarshalStrict(data, &spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// podSpec returns the spec of the pods selected by the service name: a single
+// container of the image, or the pod template with the named ports added to
+// its first container, defaulting its image and image pull secrets. With
+// connectivity checks, the container, or the first of the template, runs the
+// connect image instead.
+func podSpec(name string) v1.PodSpec {
+	if podTemplate == nil {
+		c := v1.Container{Name: name, Image: image, Ports: containerPorts()}
+		if connectMode != "" {
+			c.Image = connectImage
+			c.Args = connectArgs()
+		}
+		return v1.PodSpec{
+			Containers:       []v1.Container{c},
+			ImagePullSecrets: imagePullSecrets(),
+		}
+	}
+	spec := *podTemplate.DeepCopy()
+	if len(spec.Containers) == 0 {
+		spec.Containers = []v1.Container{{}}
+	}
+	c := &spec.Containers[0]
+	if c.Name == "" {
+		c.Name = name
+	}
+	if c.Image == "" {
+		c.Image = image
+	}
+	if connectMode != "" {
+		c.Image = connectImage
+		c.Args = connectArgs()
+	}
+	declared := make(map[string]bool)
+	for _, p := range c.Ports {
+		declared[p.Name] = true
+	}
+	for _, p := range containerPorts() {
+		if !declared[p.Name] {
+			c.Ports = append(c.Ports, p)
+		}
+	}
+	if len(spec.ImagePullSecrets) == 0 {
+		spec.ImagePullSecrets = imagePullSecrets()
+	}
+	return spec
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/kubernetes"
+)
+
+// prepopulatePrefix prefixes the names of the prepopulated services.
+const prepopulatePrefix = "kubernoisy-prepop-"
+
+// prepopulate creates n headless services, each with Endpoints of the
+// prepopulate endpoints addresses of the endpoint cidr, spread across the
+// namespaces, at the prepopulate rate, logging the progress, then waits for the
+// last one to resolve. It stops early on a signal from stop, returning true as
+// interrupted, and returns the services that were created.
+func prepopulate(kapi kubernetes.Interface, n int, stop <-chan os.Signal) ([]standingService, bool) {
+	var populated []standingService
+	// live, however long it takes
+	atomic.StoreInt32(&prepopulating, 1)
+	defer atomic.StoreInt32(&prepopulating, 0)
+	limiter := rate.NewLimiter(rate.Limit(prepopulateRate), 1)
+	step := n / 10
+	if step == 0 {
+		step = 1
+	}
+	start := clock.Now()
+	logEvent(logFields{action: "prepopulate", object: "service"}, "Prepopulating %d services at %v per second", n, prepopulateRate)
+	for i := 0; i < n; i++ {
+		select {
+		case <-stop:
+			logEvent(logFields{action: "prepopulate", object: "service"}, "Got signal, prepopulated %d of %d services", len(populated), n)
+			return populated, true
+		default:
+		}
+		limiter.Wait(opsCtx)
+		s := standingService{ns: pickNamespace(), name: prepopulatePrefix + RandStringBytes(10)}
+		if createSelectorlessService(kapi, s.ns, s.name) {
+			// kept even without its Endpoints, to be deleted at the end
+			populated = append(populated, s)
+			createEndpoints(kapi, s.ns, s.name, allocateEndpointIPs(prepopulateEndpoints))
+			PrepopulatedServices.Set(float64(len(populated)))
+		}
+		if (i+1)%step == 0 {
+			logEvent(logFields{action: "prepopulate", object: "service"}, "Prepopulated %d of %d services in %v", i+1, n, clock.Since(start).Round(time.Second))
+		}
+	}
+	if len(populated) > 0 {
+		last := populated[len(populated)-1]
+		results := verifyQueries("prepopulate", []query{{rtype: "IP", name: serviceHost(last.ns, last.name)}}, true)
+		if results[0].verified {
+			logEvent(logFields{action: "prepopulate", object: "service"}, "Prepopulated %d services in %v, the last resolved within %v", len(populated), clock.Since(start).Round(time.Second), results[0].elapsed)
+		} else {
+			logEvent(logFields{action: "prepopulate", object: "service"}, "Prepopulated %d services in %v, the last did not resolve", len(populated), clock.Since(start).Round(time.Second))
+		}
+	}
+	return populated, false
+}
+
+// unpopulate deletes the prepopulated services and their Endpoints at the
+// prepopulate rate, logging the progress.
+func unpopulate(kapi kubernetes.Interface, populated []standingService) {
+	limiter := rate.NewLimiter(rate.Limit(prepopulateRate), 1)
+	step := len(populated) / 10
+	if step == 0 {
+		step = 1
+	}
+	start := clock.Now()
+	for i, s := range populated {
+		// the ops context is done at exit, so wait on the limiter alone
+		clock.Sleep(limiter.Reserve().Delay())
+		deleteEndpoints(kapi, s.ns, s.name)
+		deleteService(kapi, s.ns, s.name)
+		PrepopulatedServices.Set(float64(len(populated) - i - 1))
+		if (i+1)%step == 0 {
+			logEvent(logFields{action: "delete", object: "service"}, "Deleted %d of %d prepopulated services in %v", i+1, len(populated), clock.Since(start).Round(time.Second))
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+func init() {
+	proxy.RegisterDialerType("http", newHTTPConnectDialer)
+}
+
+// newProxyDialer returns a dialer connecting through the proxy at rawurl, either
+// socks5://[user:password@]host:port or http://[user:password@]host:port
+// (tunnelling with CONNECT), from the dns source ip if set.
+func newProxyDialer(rawurl string) (proxy.Dialer, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	forward := &net.Dialer{}
+	if dnsSourceIP != nil {
+		forward.LocalAddr = &net.TCPAddr{IP: dnsSourceIP}
+	}
+	return proxy.FromURL(u, forward)
+}
+
+// dialProxy connects to address over tcp through the dns proxy.
+func dialProxy(ctx context.Context, address string) (net.Conn, error) {
+	if d, ok := dnsProxyDialer.(proxy.ContextDialer); ok {
+		return d.DialContext(ctx, "tcp", address)
+	}
+	return dnsProxyDialer.Dial("tcp", address)
+}
+
+// An httpConnectDialer tunnels connections through an HTTP proxy with CONNECT.
+type httpConnectDialer struct {
+	proxy   *url.URL
+	forward proxy.Dialer
+}
+
+func newHTTPConnectDialer(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	return &httpConnectDialer{proxy: u, forward: forward}, nil
+}
+
+// Dial connects to the proxy and requests a tunnel to address.
+func (d *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.forward.Dial("tcp", d.proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if u := d.proxy.User; u != nil {
+		password, _ := u.Password()
+		req.SetBasicAuth(u.Username(), password)
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		req.Header.Del("Authorization")
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		conn.Close()
+		return nil, fmt.Errorf("proxy %v refused tunnel to %v: %v", d.proxy.Host, address, resp.Status)
+	}
+	return conn, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// newPusher returns a pusher of all registered metrics to the pushgateway,
+// grouped by instance so that concurrent runs do not overwrite each other.
+func newPusher() *push.Pusher {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+	return push.New(pushgateway, pushJob).
+		Gatherer(gatherer).
+		Grouping("instance", instance)
+}
+
+// pushMetrics pushes metrics to the pushgateway at every push interval.
+func pushMetrics(pusher *push.Pusher) {
+	ticker := clock.NewTicker(pushInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		if err := pusher.Push(); err != nil {
+			logEvent(logFields{action: "push", err: err}, "could not push metrics to %v: %v", pushgateway, err)
+		}
+	}
+}
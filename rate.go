@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minTickInterval is the shortest ticker interval used. Higher rates start
+// several operations per tick instead.
+const minTickInterval = time.Millisecond
+
+// maxTickInterval is the longest ticker interval used, as very low rates
+// would overflow a time.Duration and make the ticker panic.
+const maxTickInterval = time.Duration(math.MaxInt64)
+
+// An objectRate is the rate of operations of an object given to the ops flag.
+type objectRate struct {
+	object string
+	ops    float64
+}
+
+// objectOps are the rates of each object given to the ops flag, if not a
+// single rate.
+var objectOps []objectRate
+
+// opsFlag is the ops flag: operations per second of the object flag, or a
+// comma separated list of object=ops, each object churned at its own rate.
+type opsFlag struct {
+	ops *float64
+}
+
+// String returns the rates of each object, or else the single rate.
+func (f opsFlag) String() string {
+	if f.ops == nil {
+		return ""
+	}
+	if objectOps == nil {
+		return strconv.FormatFloat(*f.ops, 'g', -1, 64)
+	}
+	pairs := make([]string, len(objectOps))
+	for i, r := range objectOps {
+		pairs[i] = r.object + "=" + strconv.FormatFloat(r.ops, 'g', -1, 64)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set sets a single rate, or the rates of each object, with ops their sum.
+func (f opsFlag) Set(s string) error {
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		*f.ops, objectOps = v, nil
+		return nil
+	}
+	var rates []objectRate
+	total := 0.0
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return fmt.Errorf("%q is neither a rate nor object=rate", pair)
+		}
+		v, err := strconv.ParseFloat(pair[i+1:], 64)
+		if err != nil {
+			return fmt.Errorf("invalid rate of %v: %v", pair[:i], err)
+		}
+		for _, r := range rates {
+			if r.object == pair[:i] {
+				return fmt.Errorf("object %v is listed twice", r.object)
+			}
+		}
+		rates = append(rates, objectRate{object: pair[:i], ops: v})
+		total += v
+	}
+	*f.ops, objectOps = total, rates
+	return nil
+}
+
+// tickInterval returns the ticker interval and the number of operations to
+// start per tick to perform ops operations per second.
+func tickInterval(ops float64) (time.Duration, int) {
+	batch := int(math.Ceil(ops * minTickInterval.Seconds()))
+	if batch < 1 {
+		batch = 1
+	}
+	interval := float64(batch) / ops * float64(time.Second)
+	if interval >= float64(maxTickInterval) {
+		return maxTickInterval, batch
+	}
+	return time.Duration(interval), batch
+}
+
+// opsTicker ticks at the interval to perform a rate of operations per second,
+// starting batch operations per tick, and can be reset to another rate.
+type opsTicker struct {
+	Ticker
+	ops      float64
+	interval time.Duration
+	batch    int
+}
+
+// newOpsTicker returns an opsTicker for ops operations per second.
+func newOpsTicker(ops float64) *opsTicker {
+	t := &opsTicker{}
+	t.interval, t.batch = tickInterval(ops)
+	t.Ticker = clock.NewTicker(t.interval)
+	t.ops = ops
+	setCurrentOps(ops)
+	setTickInterval(t.interval)
+	TickBatchSize.Set(float64(t.batch))
+	return t
+}
+
+// setRate resets the ticker to ops operations per second.
+func (t *opsTicker) setRate(ops float64) {
+	if ops == t.ops {
+		return
+	}
+	t.interval, t.batch = tickInterval(ops)
+	t.Reset(t.interval)
+	t.ops = ops
+	setCurrentOps(ops)
+	setTickInterval(t.interval)
+	TickBatchSize.Set(float64(t.batch))
+}
+
+// poisson resets the ticker to tick after an exponentially distributed delay
+// with the mean of its interval, so that operations arrive as a Poisson
+// process at its rate.
+func (t *opsTicker) poisson() {
+	next := time.Duration(rand.ExpFloat64() * float64(t.interval))
+	if next < minTickInterval {
+		next = minTickInterval
+	}
+	t.Reset(next)
+}
+
+// profiles are the load profiles of the profile flag.
+var profiles = map[string]bool{"constant": true, "ramp": true, "burst": true, "sine": true, "poisson": true}
+
+// profileOps returns the target rate of the load profile elapsed into it, and
+// whether the rate still varies. Poisson arrivals vary the delays between
+// operations rather than the rate.
+func profileOps(elapsed time.Duration) (float64, bool) {
+	switch profile {
+	case "ramp":
+		return rampOps(elapsed), elapsed < rampDuration
+	case "burst":
+		if elapsed%profilePeriod < burstDuration {
+			return burstOps, true
+		}
+		return ops, true
+	case "sine":
+		return ops + sineAmplitude*math.Sin(2*math.Pi*elapsed.Seconds()/profilePeriod.Seconds()), true
+	}
+	return ops, false
+}
+
+// lowestOps returns the lowest target rate of operations: of the slowest
+// workload, or the lowest the load profile passes through.
+func lowestOps() float64 {
+	if workloads != nil {
+		lowest := math.Inf(1)
+		for _, w := range workloads {
+			lowest = math.Min(lowest, w.ops)
+		}
+		return lowest
+	}
+	switch profile {
+	case "ramp":
+		return math.Min(rampFrom, rampTo)
+	case "burst":
+		return math.Min(ops, burstOps)
+	case "sine":
+		return ops - sineAmplitude
+	}
+	return ops
+}
+
+// rampOps returns the rate of the ramp elapsed into it, rising or falling
+// linearly from the ramp start to its target over the ramp duration, then
+// holding at the target.
+func rampOps(elapsed time.Duration) float64 {
+	if elapsed >= rampDuration {
+		return rampTo
+	}
+	return rampFrom + (rampTo-rampFrom)*elapsed.Seconds()/rampDuration.Seconds()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTickInterval(t *testing.T) {
+	tests := []struct {
+		ops      float64
+		interval time.Duration
+		batch    int
+	}{
+		{ops: 0.5, interval: 2 * time.Second, batch: 1},
+		{ops: 1, interval: time.Second, batch: 1},
+		{ops: 5, interval: 200 * time.Millisecond, batch: 1},
+		{ops: 100, interval: 10 * time.Millisecond, batch: 1},
+		{ops: 1000, interval: time.Millisecond, batch: 1},
+		// beyond one per millisecond, several operations start per tick
+		{ops: 2500, interval: 1200 * time.Microsecond, batch: 3},
+		{ops: 10000, interval: time.Millisecond, batch: 10},
+		// a tick interval overflowing a time.Duration is clamped
+		{ops: 1e-12, interval: maxTickInterval, batch: 1},
+	}
+	for _, tt := range tests {
+		interval, batch := tickInterval(tt.ops)
+		if interval != tt.interval || batch != tt.batch {
+			t.Errorf("tickInterval(%v) = %v, %d; want %v, %d", tt.ops, interval, batch, tt.interval, tt.batch)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// dnsReplicaResolvers returns a required resolver for each ready endpoint of
+// the DNS service ref (namespace/name, or name in kube-system), named after
+// its pod, sorted by name.
+func dnsReplicaResolvers(kapi kubernetes.Interface, ref string) ([]namedResolver, error) {
+	ns, name := "kube-system", ref
+	if i := strings.Index(ref, "/"); i >= 0 {
+		ns, name = ref[:i], ref[i+1:]
+	}
+	ctx, cancel := apiContext()
+	defer cancel()
+	ep, err := kapi.CoreV1().Endpoints(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var resolvers []namedResolver
+	for _, s := range ep.Subsets {
+		port := 53
+		for _, p := range s.Ports {
+			if p.Name == "dns" {
+				port = int(p.Port)
+			}
+		}
+		for _, a := range s.Addresses {
+			addr := net.JoinHostPort(a.IP, strconv.Itoa(port))
+			replica := a.IP
+			if a.TargetRef != nil {
+				replica = a.TargetRef.Name
+			}
+			var r dnsResolver = newResolver(addr)
+			if directDNS {
+				r = newDirectResolver(addr)
+			}
+			resolvers = append(resolvers, namedResolver{replica, r})
+		}
+	}
+	if len(resolvers) == 0 {
+		return nil, fmt.Errorf("no ready endpoints")
+	}
+	sort.Slice(resolvers, func(i, j int) bool { return resolvers[i].name < resolvers[j].name })
+	return resolvers, nil
+}
+
+// resolverNames returns the names of resolvers.
+func resolverNames(resolvers []namedResolver) []string {
+	names := make([]string, len(resolvers))
+	for i, r := range resolvers {
+		names[i] = r.name
+	}
+	return names
+}
+
+// refreshDNSReplicas rediscovers the replicas of the DNS service every
+// interval, so that verifications follow rollouts and scaling of the DNS.
+func refreshDNSReplicas(kapi kubernetes.Interface, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		resolvers, err := dnsReplicaResolvers(kapi, dnsReplicas)
+		if err != nil {
+			logEvent(logFields{action: "replicas", object: "endpoints", err: err}, "could not discover replicas of %v: %v", dnsReplicas, err)
+			continue
+		}
+		if names := resolverNames(resolvers); strings.Join(names, ",") != strings.Join(resolverNames(verifyResolvers()), ",") {
+			logEvent(logFields{action: "replicas", object: "endpoints"}, "Verifying DNS on each replica of %v: %v", dnsReplicas, strings.Join(names, ", "))
+		}
+		setRequiredResolvers(resolvers)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportQuantiles are the validation latency percentiles of the report.
+var reportQuantiles = []float64{0.5, 0.9, 0.99}
+
+// A report is the end of run summary of the report flag.
+type report struct {
+	Operations  int64          `json:"operations"`
+	Failed      int64          `json:"failed"`
+	FailureRate float64        `json:"failure_rate"`
+	Validations []reportAction `json:"validations"`
+}
+
+// A reportAction is the summary of the validations of an action and record type.
+type reportAction struct {
+	Action   string  `json:"action"`
+	Verified int64   `json:"verified"`
+	Failed   int64   `json:"failed"`
+	P50      float64 `json:"p50_seconds"`
+	P90      float64 `json:"p90_seconds"`
+	P99      float64 `json:"p99_seconds"`
+}
+
+// summaryActions returns the actions with validations in the summary, sorted.
+// The caller holds samplesMu.
+func summaryActions() []string {
+	actions := make(map[string]bool)
+	for action := range samples {
+		actions[action] = true
+	}
+	for action := range failures {
+		actions[action] = true
+	}
+	var names []string
+	for action := range actions {
+		names = append(names, action)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newReport returns the report of the run so far.
+func newReport() report {
+	done, failed := opsCounts()
+	rep := report{Operations: done, Failed: failed}
+	if done > 0 {
+		rep.FailureRate = float64(failed) / float64(done)
+	}
+	samplesMu.Lock()
+	defer samplesMu.Unlock()
+	for _, action := range summaryActions() {
+		a := reportAction{Action: action, Failed: failures[action]}
+		if r, ok := samples[action]; ok {
+			var q []float64
+			q, a.Verified = r.Quantiles(reportQuantiles...)
+			a.P50, a.P90, a.P99 = q[0], q[1], q[2]
+		}
+		rep.Validations = append(rep.Validations, a)
+	}
+	return rep
+}
+
+// writeReport writes rep to file, as CSV if it is named .csv, otherwise as
+// JSON. The CSV has a row for all operations, then one per action.
+func writeReport(file string, rep report) error {
+	if !strings.HasSuffix(file, ".csv") {
+		b, err := json.MarshalIndent(rep, "", "  ")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(file, append(b, '\n'), 0644)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"action", "succeeded", "failed", "p50_seconds", "p90_seconds", "p99_seconds"})
+	w.Write([]string{"operations", strconv.FormatInt(rep.Operations-rep.Failed, 10), strconv.FormatInt(rep.Failed, 10), "", "", ""})
+	for _, a := range rep.Validations {
+		w.Write([]string{a.Action, strconv.FormatInt(a.Verified, 10), strconv.FormatInt(a.Failed, 10),
+			formatSeconds(a.P50), formatSeconds(a.P90), formatSeconds(a.P99)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// formatSeconds formats a latency in seconds for the CSV report.
+func formatSeconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', 3, 64)
+}
+
+// reportViolations returns the thresholds rep exceeds: the max failure rate of
+// the operations, and the max p99 of the validations of any action.
+func reportViolations(rep report) []string {
+	var violations []string
+	if rep.FailureRate > maxFailureRate {
+		violations = append(violations, fmt.Sprintf("failure rate %.4f exceeds %.4f", rep.FailureRate, maxFailureRate))
+	}
+	if maxP99 > 0 {
+		for _, a := range rep.Validations {
+			if p99 := time.Duration(a.P99 * float64(time.Second)); a.Verified > 0 && p99 > maxP99 {
+				violations = append(violations, fmt.Sprintf("validation %v p99 %v exceeds %v", a.Action, p99, maxP99))
+			}
+		}
+	}
+	return violations
+}
+
+// finishReport writes the report, if enabled, and returns the exit code of
+// the run: 1 if it exceeds a threshold, otherwise 0.
+func finishReport() int {
+	rep := newReport()
+	if reportFile != "" {
+		if err := writeReport(reportFile, rep); err != nil {
+			logEvent(logFields{action: "report", err: err}, "could not write report %v: %v", reportFile, err)
+		}
+	}
+	violations := reportViolations(rep)
+	for _, v := range violations {
+		logEvent(logFields{action: "report"}, "Threshold exceeded: %v", v)
+	}
+	if len(violations) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// resetter resets the metrics served, if the debug endpoints are enabled.
+var resetter *resetGatherer
+
+// A resetGatherer reports the kubernoisy counters and histograms of a
+// gatherer relative to their values at the last reset, so that they start
+// over without restarting. Gauges keep reporting their current values.
+type resetGatherer struct {
+	prometheus.Gatherer
+
+	mu       sync.Mutex
+	baseline map[string]*dto.Metric
+}
+
+// newResetGatherer returns a gatherer of g that can be reset.
+func newResetGatherer(g prometheus.Gatherer) *resetGatherer {
+	return &resetGatherer{Gatherer: g, baseline: make(map[string]*dto.Metric)}
+}
+
+// Reset makes the current values of the counters and histograms their zero.
+func (g *resetGatherer) Reset() error {
+	families, err := g.Gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	baseline := make(map[string]*dto.Metric)
+	for _, mf := range families {
+		if !strings.HasPrefix(mf.GetName(), "kubernoisy_") {
+			continue
+		}
+		for _, m := range mf.Metric {
+			baseline[metricKey(mf, m)] = m
+		}
+	}
+	g.mu.Lock()
+	g.baseline = baseline
+	g.mu.Unlock()
+	return nil
+}
+
+// Gather gathers the metrics of the wrapped gatherer, less their values at the
+// last reset.
+func (g *resetGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			if base, ok := g.baseline[metricKey(mf, m)]; ok {
+				subtractMetric(m, base)
+			}
+		}
+	}
+	return families, err
+}
+
+// metricKey returns the family name and labels of m, identifying it across gathers.
+func metricKey(mf *dto.MetricFamily, m *dto.Metric) string {
+	key := mf.GetName()
+	for _, l := range m.Label {
+		key += "\xff" + l.GetName() + "=" + l.GetValue()
+	}
+	return key
+}
+
+// subtractMetric subtracts the counter or histogram values of base from m.
+func subtractMetric(m, base *dto.Metric) {
+	if m.Counter != nil && base.Counter != nil {
+		v := m.Counter.GetValue() - base.Counter.GetValue()
+		m.Counter.Value = &v
+	}
+	if h, b := m.Histogram, base.Histogram; h != nil && b != nil {
+		count := h.GetSampleCount() - b.GetSampleCount()
+		sum := h.GetSampleSum() - b.GetSampleSum()
+		h.SampleCount, h.SampleSum = &count, &sum
+		for i, bucket := range h.Bucket {
+			if i < len(b.Bucket) {
+				c := bucket.GetCumulativeCount() - b.Bucket[i].GetCumulativeCount()
+				bucket.CumulativeCount = &c
+			}
+		}
+	}
+}
+
+// handleReset resets the counters and histograms served, and the exit summary,
+// so that a tuning iteration starts clean without restarting.
+func handleReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if nativeHistograms {
+		// the sparse buckets of native histograms are not reset
+		http.Error(w, "reset is not supported with native histograms", http.StatusConflict)
+		return
+	}
+	if err := resetter.Reset(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resetSummary()
+	atomic.StoreInt64(&opsDone, 0)
+	atomic.StoreInt64(&opsFailed, 0)
+	logEvent(logFields{action: "reset"}, "Reset metrics")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// setupResolver configures the resolvers used for verification.
+func setupResolver(config *rest.Config, kapi kubernetes.Interface) error {
+	var err error
+	if dnsSourceIPStr != "" {
+		dnsSourceIP, err = parseLocalIP(dnsSourceIPStr)
+		if err != nil {
+			return fmt.Errorf("invalid dns-source-ip: %v", err)
+		}
+		logEvent(logFields{action: "resolver"}, "Sending DNS queries from %v", dnsSourceIP)
+	}
+
+	if dnsProxy != "" {
+		dnsProxyDialer, err = newProxyDialer(dnsProxy)
+		if err != nil {
+			return fmt.Errorf("invalid dns-proxy: %v", err)
+		}
+		logEvent(logFields{action: "resolver"}, "Sending DNS queries over tcp through %v", dnsProxy)
+	}
+
+	if requireResolvers != "" {
+		for _, addr := range strings.Split(requireResolvers, ",") {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				addr = net.JoinHostPort(addr, "53")
+			}
+			var r dnsResolver = newResolver(addr)
+			if directDNS {
+				r = newDirectResolver(addr)
+			}
+			requiredResolvers = append(requiredResolvers, namedResolver{addr, r})
+		}
+		logEvent(logFields{action: "resolver"}, "Verifying DNS on each of %v", requireResolvers)
+	}
+
+	if dnsReplicas != "" {
+		resolvers, err := dnsReplicaResolvers(kapi, dnsReplicas)
+		if err != nil {
+			return fmt.Errorf("could not discover replicas of %v: %v", dnsReplicas, err)
+		}
+		setRequiredResolvers(resolvers)
+		logEvent(logFields{action: "resolver"}, "Verifying DNS on each replica of %v: %v", dnsReplicas, strings.Join(resolverNames(resolvers), ", "))
+	}
+
+	switch {
+	case execPodRef != "":
+		execPod, err = newExecResolver(config, kapi, execPodRef)
+		if err != nil {
+			return err
+		}
+		resolver = execPod
+		logEvent(logFields{action: "resolver"}, "Verifying DNS from pod %v.%v in zone %v", execPod.name, execPod.namespace, execPod.zone)
+	case mimicPodRef != "":
+		m, err := newMimicResolver(config, kapi, mimicPodRef)
+		if err != nil {
+			return err
+		}
+		resolver, resolverServer = m, net.JoinHostPort(m.servers[0], "53")
+		logEvent(logFields{action: "resolver"}, "Verifying DNS as pod %v with %v", mimicPodRef, m)
+	case dnsServer != "":
+		server := dnsServer
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver, resolverServer = newResolver(server), server
+		logEvent(logFields{action: "resolver"}, "Verifying DNS via %v", server)
+	case nodeLocalDNS:
+		server := net.JoinHostPort(nodeLocalDNSIP, "53")
+		r := newResolver(server)
+		if err := checkReachable(r); err != nil {
+			return fmt.Errorf("nodelocal dns %v is not reachable: %v", server, err)
+		}
+		resolver, resolverServer = r, server
+		logEvent(logFields{action: "resolver"}, "Verifying DNS via NodeLocal DNSCache %v", nodeLocalDNSIP)
+	case resolverFamily != "":
+		var server string
+		resolver, server, err = familyResolver(resolverFamily)
+		if err != nil {
+			return err
+		}
+		resolverServer = net.JoinHostPort(server, "53")
+		logEvent(logFields{action: "resolver"}, "Verifying DNS via %v nameserver %v", resolverFamily, server)
+	case dnsSourceIP != nil || dnsProxyDialer != nil || queryTimeout > 0:
+		// the go resolver honours the deadline of each lookup
+		resolver = newResolver("")
+	}
+
+	if directDNS {
+		server, err := ttlServer()
+		if err != nil {
+			return err
+		}
+		resolver, resolverServer = newDirectResolver(server), server
+		logEvent(logFields{action: "resolver"}, "Querying DNS directly, without search path, via %v", server)
+	}
+	return nil
+}
+
+// newResolver returns a resolver that sends all queries to server
+// (host:port), or to the resolv.conf nameservers if empty, from the dns source
+// ip if set, and through the dns proxy if set.
+func newResolver(server string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if server != "" {
+				address = server
+			}
+			if dnsProxyDialer != nil {
+				// proxies only tunnel tcp, which the resolver detects from the connection
+				return dialProxy(ctx, address)
+			}
+			var d net.Dialer
+			if dnsSourceIP != nil {
+				if strings.HasPrefix(network, "tcp") {
+					d.LocalAddr = &net.TCPAddr{IP: dnsSourceIP}
+				} else {
+					d.LocalAddr = &net.UDPAddr{IP: dnsSourceIP}
+				}
+			}
+			return d.DialContext(ctx, network, address)
+		},
+	}
+}
+
+// checkReachable returns an error unless r gets an answer, even a negative one.
+func checkReachable(r dnsResolver) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := r.LookupHost(ctx, "kubernetes.default.svc."+clusterDomain+".")
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return nil
+	}
+	return err
+}
+
+// parseLocalIP parses s as an ip address assigned to a local interface.
+func parseLocalIP(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip %q", s)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("%v is not assigned to a local interface", ip)
+}
+
+// familyResolver returns a resolver using the first nameserver of family
+// listed in the local resolv.conf.
+func familyResolver(family string) (*net.Resolver, string, error) {
+	conf, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, "", err
+	}
+	server, err := pickNameserver(parseNameservers(string(conf)), family)
+	if err != nil {
+		return nil, "", err
+	}
+	return newResolver(net.JoinHostPort(server, "53")), server, nil
+}
+
+// parseNameservers returns the nameserver addresses in resolv.conf content.
+func parseNameservers(conf string) []string {
+	var servers []string
+	s := bufio.NewScanner(strings.NewReader(conf))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) >= 2 && f[0] == "nameserver" {
+			servers = append(servers, f[1])
+		}
+	}
+	return servers
+}
+
+// pickNameserver returns the first of servers in the address family, "ipv4" or "ipv6".
+func pickNameserver(servers []string, family string) (string, error) {
+	for _, s := range servers {
+		// strip any zone from link-local ipv6 addresses
+		ip := net.ParseIP(strings.SplitN(s, "%", 2)[0])
+		if ip == nil {
+			continue
+		}
+		if (family == "ipv4") == (ip.To4() != nil) {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("no %v nameserver in %v", family, servers)
+}
+
+// familyLabel returns the resolver family metric label value.
+func familyLabel() string {
+	if resolverFamily == "" {
+		return "any"
+	}
+	return resolverFamily
+}
+
+// validationMethod returns the validation method metric label value: exec
+// (nslookup in the exec pod), mimic (as the mimicked pod), direct (the DNS
+// client of its own) or resolver (the Go resolver).
+func validationMethod() string {
+	switch {
+	case execPodRef != "":
+		return "exec"
+	case mimicPodRef != "":
+		return "mimic"
+	case directDNS:
+		return "direct"
+	}
+	return "resolver"
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// retryCreate calls create until it succeeds, fails with an error that is not
+// worth retrying, or the create retries are exhausted, backing off
+// exponentially from the retry delay, or for as long as the API server asks.
+// An object that already exists on a retry was created by an earlier attempt
+// that timed out, and counts as created.
+func retryCreate(resource string, create func(ctx context.Context) error) error {
+	return retryCall(create, errors.IsAlreadyExists, CreateRetryCount.WithLabelValues(resource))
+}
+
+// retryDelete calls del as retryCreate does create. An object that is already
+// gone on a retry was deleted by an earlier attempt that timed out, and counts
+// as deleted.
+func retryDelete(resource string, del func(ctx context.Context) error) error {
+	return retryCall(del, errors.IsNotFound, DeleteRetryCount.WithLabelValues(resource))
+}
+
+// retryCall calls f until it succeeds, fails with an error that is not worth
+// retrying, or the retries are exhausted, counting each retry. Each attempt
+// is given its own api context. An error that done returns true for on a
+// retry counts as success.
+func retryCall(f func(ctx context.Context) error, done func(error) bool, retries prometheus.Counter) error {
+	delay := createRetryDelay
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := apiContext()
+		err := f(ctx)
+		cancel()
+		if attempt > 0 && done(err) {
+			return nil
+		}
+		if err == nil || attempt >= createRetries || !retriable(err) || abandoned() || !spendRetry() {
+			return err
+		}
+		retries.Inc()
+		wait := delay
+		if s, ok := errors.SuggestsClientDelay(err); ok && time.Duration(s)*time.Second > wait {
+			wait = time.Duration(s) * time.Second
+		}
+		clock.Sleep(wait)
+		delay *= 2
+	}
+}
+
+// apiContext returns the context of an API request, done after the api
+// timeout if set, and the function releasing it. It is not that of the
+// operations, so that the objects of an abandoned operation are still
+// deleted on shutdown.
+func apiContext() (context.Context, context.CancelFunc) {
+	if apiTimeout > 0 {
+		return context.WithTimeout(context.Background(), apiTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// retriesLeft is the remaining retry budget, and budgetExhausted is set once
+// it is exhausted.
+var (
+	retriesLeft     int64
+	budgetExhausted int32
+)
+
+// spendRetry takes a retry from the retry budget, returning false if it is
+// exhausted. Every retry is allowed without a budget.
+func spendRetry() bool {
+	if retryBudget == 0 {
+		return true
+	}
+	left := atomic.AddInt64(&retriesLeft, -1)
+	if left < 0 {
+		RetryBudgetExhaustedCount.Inc()
+		if atomic.CompareAndSwapInt32(&budgetExhausted, 0, 1) {
+			logEvent(logFields{action: "retry"}, "Retry budget of %d retries exhausted, no longer retrying", retryBudget)
+		}
+		return false
+	}
+	RetryBudgetRemaining.Set(float64(left))
+	return true
+}
+
+// retriable returns true if err is a transient failure of the API server to
+// process a request, e.g. under load or restarting, rather than a rejection of
+// it.
+func retriable(err error) bool {
+	return errors.IsTooManyRequests(err) || errors.IsServerTimeout(err) || errors.IsTimeout(err) ||
+		errors.IsServiceUnavailable(err) || errors.IsInternalError(err) ||
+		utilnet.IsConnectionRefused(err) || utilnet.IsConnectionReset(err) || isClientTimeout(err)
+}
+
+// isClientTimeout returns true if err is a request abandoned after the api
+// timeout.
+func isClientTimeout(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// verifyReuse deletes the pods and service name, waits for the pods to be
+// removed, then recreates them all under the same name and verifies that host
+// resolves to exactly the addresses of the new pods, counting answers with the
+// addresses of the old pods as stale and recording under the reuse action how
+// long they persisted.
+func verifyReuse(kapi kubernetes.Interface, ns, name, host string) bool {
+	oldIPs, err := servicePodIPs(kapi, ns, name)
+	if err != nil {
+		logEvent(logFields{action: "reuse", object: "pod", name: name, namespace: ns, err: err}, "could not get ips of pods of %v.%v: %v", name, ns, err)
+		return false
+	}
+	if !deletePods(kapi, ns, name) || !deleteService(kapi, ns, name) {
+		return false
+	}
+	if err := waitPodsDeleted(kapi, ns, name); err != nil {
+		logEvent(logFields{action: "reuse", object: "pod", name: name, namespace: ns, err: err}, "could not wait for pods of %v.%v to be deleted: %v", name, ns, err)
+		return false
+	}
+	if !createServiceObjects(kapi, ns, name, pickCreateOrder()) {
+		return false
+	}
+	newIPs, err := servicePodIPs(kapi, ns, name)
+	if err != nil {
+		logEvent(logFields{action: "reuse", object: "pod", name: name, namespace: ns, err: err}, "could not get ips of pods of %v.%v: %v", name, ns, err)
+		return false
+	}
+
+	// an address reused by a new pod is not stale
+	var stale []string
+	for _, ip := range oldIPs {
+		if !anyAnswer([]string{ip}, newIPs) {
+			stale = append(stale, ip)
+		}
+	}
+	results := verifyQueries("reuse", []query{{rtype: "IP", name: host, want: newIPs, stale: stale}}, true)
+	return allVerified(results)
+}
+
+// waitPodsDeleted polls the pods of the service name until they are removed,
+// up to the timeout of their workload, so that they can be created again
+// under the same names.
+func waitPodsDeleted(kapi kubernetes.Interface, ns, name string) error {
+	for start := clock.Now(); clock.Since(start) < workloadOf(name).timeout && !abandoned(); clock.Sleep(pollInterval) {
+		gone := 0
+		for i := 0; i < replicas; i++ {
+			ctx, cancel := apiContext()
+			_, err := kapi.CoreV1().Pods(ns).Get(ctx, podName(name, i), metav1.GetOptions{})
+			cancel()
+			if errors.IsNotFound(err) {
+				gone++
+			} else if err != nil {
+				return err
+			}
+		}
+		if gone == replicas {
+			return nil
+		}
+	}
+	return fmt.Errorf("timed out after %v", timeout.Truncate(time.Second))
+}
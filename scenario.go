@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// A workload is a stream of cycles of an object at its own rate, whose objects
+// carry its labels and whose records are verified by its record type within
+// its timeout. Without a scenario file, the flags describe a single workload.
+type workload struct {
+	name       string
+	object     string
+	cycle      func(kapi kubernetes.Interface, w *workload, b *batch) bool
+	ops        float64
+	labels     map[string]string
+	recordType string
+	timeout    time.Duration
+}
+
+// scenario is the YAML scenario file of the config flag.
+type scenario struct {
+	Workloads []struct {
+		Name       string            `json:"name"`
+		Object     string            `json:"object"`
+		Ops        float64           `json:"ops"`
+		Labels     map[string]string `json:"labels"`
+		RecordType string            `json:"recordType"`
+		Timeout    string            `json:"timeout"`
+	} `json:"workloads"`
+}
+
+// defaultWorkload is the workload of the flags, and workloads are those of the
+// scenario file, if any.
+var (
+	defaultWorkload *workload
+	workloads       []*workload
+)
+
+// boundWorkloads are the workloads of the cycles in progress, by the name of
+// their objects.
+var boundWorkloads sync.Map
+
+// loadScenario returns the workloads of the scenario file at path, each
+// defaulting to the object, rate, record type and timeout of def, and running
+// the additional kinds alongside.
+func loadScenario(path string, def *workload, kinds []string) ([]*workload, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s scenario
+	if err := yaml.UnmarshalStrict(data, &s); err != nil {
+		return nil, err
+	}
+	if len(s.Workloads) == 0 {
+		return nil, fmt.Errorf("no workloads")
+	}
+	var ws []*workload
+	names := make(map[string]bool)
+	for i, sw := range s.Workloads {
+		w := &workload{name: sw.Name, object: def.object, ops: def.ops, labels: sw.Labels, recordType: def.recordType, timeout: def.timeout}
+		if w.name == "" {
+			w.name = fmt.Sprintf("workload-%d", i)
+		}
+		if names[w.name] {
+			return nil, fmt.Errorf("workload %q is listed twice", w.name)
+		}
+		names[w.name] = true
+		if sw.Object != "" {
+			w.object = sw.Object
+		}
+		if sw.Ops != 0 {
+			w.ops = sw.Ops
+		}
+		if sw.RecordType != "" {
+			w.recordType = sw.RecordType
+		}
+		if sw.Timeout != "" {
+			if w.timeout, err = time.ParseDuration(sw.Timeout); err != nil {
+				return nil, fmt.Errorf("workload %v: invalid timeout: %v", w.name, err)
+			}
+		}
+		cycle, ok := cycles[w.object]
+		if !ok {
+			return nil, fmt.Errorf("workload %v: unknown object %q", w.name, w.object)
+		}
+		w.cycle = withKinds(cycle, kinds)
+		if err := w.validate(); err != nil {
+			return nil, fmt.Errorf("workload %v: %v", w.name, err)
+		}
+		ws = append(ws, w)
+	}
+	return ws, nil
+}
+
+// objectWorkloads returns a workload named after the object of each of rates,
+// at its rate, with the record type and timeout of def, and running the
+// additional kinds alongside.
+func objectWorkloads(rates []objectRate, def *workload, kinds []string) ([]*workload, error) {
+	var ws []*workload
+	for _, r := range rates {
+		cycle, ok := cycles[r.object]
+		if !ok {
+			return nil, fmt.Errorf("unknown object %q", r.object)
+		}
+		w := &workload{name: r.object, object: r.object, cycle: withKinds(cycle, kinds), ops: r.ops, recordType: def.recordType, timeout: def.timeout}
+		if err := w.validate(); err != nil {
+			return nil, fmt.Errorf("%v: %v", r.object, err)
+		}
+		ws = append(ws, w)
+	}
+	return ws, nil
+}
+
+// validate returns an error if the settings of w are not valid.
+func (w *workload) validate() error {
+	if w.ops <= 0 {
+		return fmt.Errorf("ops must be > 0")
+	}
+	if w.timeout <= 0 {
+		return fmt.Errorf("timeout must be > 0")
+	}
+	if w.recordType != "IP" && w.recordType != "A" && w.recordType != "AAAA" && w.recordType != "SRV" {
+		return fmt.Errorf("unknown record type %q", w.recordType)
+	}
+	if w.recordType != "IP" && (w.object != "service" || verifyAllRecords) {
+		return fmt.Errorf("record type is only supported for services without verify-all-records")
+	}
+	for k, v := range w.labels {
+		if k == "app" || k == "kubernoisy" || k == traceLabel || k == "kubernoisy-shard" {
+			return fmt.Errorf("label %q is reserved", k)
+		}
+		if errs := append(validation.IsQualifiedName(k), validation.IsValidLabelValue(v)...); len(errs) > 0 {
+			return fmt.Errorf("invalid label %v=%v: %v", k, v, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// usesObject returns true if any workload churns object.
+func usesObject(object string) bool {
+	for _, w := range allWorkloads() {
+		if w.object == object {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyObject returns true if every workload churns object.
+func onlyObject(object string) bool {
+	for _, w := range allWorkloads() {
+		if w.object != object {
+			return false
+		}
+	}
+	return true
+}
+
+// allWorkloads returns the workloads of the scenario file, or else the
+// workload of the flags.
+func allWorkloads() []*workload {
+	if workloads != nil {
+		return workloads
+	}
+	return []*workload{defaultWorkload}
+}
+
+// bindWorkload binds the objects of the cycle name to workload w until the
+// returned function is called.
+func bindWorkload(name string, w *workload) func() {
+	if w == nil || w == defaultWorkload {
+		return func() {}
+	}
+	boundWorkloads.Store(name, w)
+	return func() { boundWorkloads.Delete(name) }
+}
+
+// workloadOf returns the workload of the object or host name, or the
+// workload of the flags if none.
+func workloadOf(name string) *workload {
+	if w, ok := loadByName(&boundWorkloads, name); ok {
+		return w.(*workload)
+	}
+	return defaultWorkload
+}
+
+// workloadLabels returns labels with the labels of the workload of the
+// object name added, and the shard and trace labels if any.
+func workloadLabels(name string, labels map[string]string) map[string]string {
+	for k, v := range workloadOf(name).labels {
+		labels[k] = v
+	}
+	return traceLabels(name, shardLabels(labels))
+}
+
+// runWorkload starts the cycles of w at its rate until shutdown.
+func runWorkload(kapi kubernetes.Interface, w *workload) {
+	interval, batch := tickInterval(w.ops)
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	WorkloadOps.WithLabelValues(w.name, w.object).Set(w.ops)
+	logEvent(logFields{action: "rate", object: w.object}, "Workload %v performing %v %v operations per second (%v per %v tick)", w.name, w.ops, w.object, batch, interval)
+	for range ticker.C() {
+		if draining() || opsCtx.Err() != nil {
+			return
+		}
+		recordTick()
+		if errorBackoff.active() || namespaceRecreating() || opsPaused() {
+			continue
+		}
+		launchCycles(kapi, w, batch)
+	}
+}
+
+// launchCycles launches n cycles of w, or batches of cycles, due at a tick,
+// counting them by result.
+func launchCycles(kapi kubernetes.Interface, w *workload, n int) {
+	atomic.AddInt64(&opsDue, int64(n))
+	for i := 0; i < n; i++ {
+		launch(func() bool {
+			var ok bool
+			if batchSize > 1 {
+				ok = runBatch(kapi, w, w.cycle)
+			} else {
+				ok = w.cycle(kapi, w, nil)
+			}
+			result := "success"
+			if !ok {
+				result = "failure"
+			}
+			WorkloadOperationCount.WithLabelValues(w.name, w.object, result).Inc()
+			return ok
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// namePrefix prefixes the names of the objects created by the cycles. With
+// sharding, it includes the shard tag, so that the replicas of a group never
+// create the same names.
+var namePrefix = "kubernoisy-"
+
+// shardIdle is 1 while the shard has no share of the operations assigned by
+// the leader.
+var shardIdle int32
+
+// shardTag returns the short tag of the shard id, used in names and labels.
+func shardTag() string {
+	h := fnv.New32a()
+	h.Write([]byte(shardID))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// shardSelector returns the label selector of the objects created by this
+// shard, or by any instance without sharding.
+func shardSelector() string {
+	if shardGroup == "" {
+		return "kubernoisy=noise"
+	}
+	return "kubernoisy=noise,kubernoisy-shard=" + shardTag()
+}
+
+// shardLabels adds the shard label to labels.
+func shardLabels(labels map[string]string) map[string]string {
+	if shardGroup != "" {
+		labels["kubernoisy-shard"] = shardTag()
+	}
+	return labels
+}
+
+// startSharding joins the shard group: it renews the membership lease of the
+// shard, runs for leader, and applies the share of the operations assigned by
+// the leader. Operations are idle until the first assignment.
+func startSharding(kapi kubernetes.Interface) {
+	namePrefix = "kubernoisy-" + shardTag() + "-"
+	atomic.StoreInt32(&shardIdle, 1)
+	logEvent(logFields{action: "shard", object: "lease", namespace: shardNamespace}, "Joining shard group %v in %v as %v (%v)", shardGroup, shardNamespace, shardID, shardTag())
+
+	go renewMember(kapi)
+	go followShards(kapi)
+	go leaderelection.RunOrDie(opsCtx, leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: shardGroup + "-leader", Namespace: shardNamespace},
+			Client:     kapi.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: shardID},
+		},
+		LeaseDuration:   3 * shardInterval,
+		RenewDeadline:   2 * shardInterval,
+		RetryPeriod:     shardInterval / 2,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logEvent(logFields{action: "shard", object: "lease", namespace: shardNamespace}, "Leading shard group %v", shardGroup)
+				assignShards(ctx, kapi)
+			},
+			OnStoppedLeading: func() {
+				logEvent(logFields{action: "shard", object: "lease", namespace: shardNamespace}, "Stopped leading shard group %v", shardGroup)
+			},
+		},
+	})
+}
+
+// memberLease returns the name of the membership lease of the shard.
+func memberLease() string {
+	return shardGroup + "-" + shardTag()
+}
+
+// renewMember creates and renews the membership lease of the shard every
+// shard interval, deleting it on shutdown so that the leader reassigns its
+// share at once.
+func renewMember(kapi kubernetes.Interface) {
+	leases := kapi.CoordinationV1().Leases(shardNamespace)
+	ticker := clock.NewTicker(shardInterval)
+	defer ticker.Stop()
+	for {
+		now := metav1.NewMicroTime(clock.Now())
+		ctx, cancel := apiContext()
+		l, err := leases.Get(ctx, memberLease(), metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+			_, err = leases.Create(ctx, &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      memberLease(),
+					Namespace: shardNamespace,
+					Labels:    map[string]string{"kubernoisy-shard-group": shardGroup},
+				},
+				Spec: coordinationv1.LeaseSpec{HolderIdentity: &shardID, RenewTime: &now},
+			}, metav1.CreateOptions{})
+		case err == nil:
+			l.Spec.HolderIdentity = &shardID
+			l.Spec.RenewTime = &now
+			_, err = leases.Update(ctx, l, metav1.UpdateOptions{})
+		}
+		cancel()
+		if err != nil {
+			logEvent(logFields{action: "shard", object: "lease", name: memberLease(), namespace: shardNamespace, err: err}, "could not renew shard lease %v.%v: %v", memberLease(), shardNamespace, err)
+		}
+		select {
+		case <-ticker.C():
+		case <-opsCtx.Done():
+			ctx, cancel := apiContext()
+			defer cancel()
+			if err := leases.Delete(ctx, memberLease(), metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				logEvent(logFields{action: "delete", object: "lease", name: memberLease(), namespace: shardNamespace, err: err}, "could not delete shard lease %v.%v: %v", memberLease(), shardNamespace, err)
+			}
+			return
+		}
+	}
+}
+
+// shardMembers returns the sorted ids of the members of the group whose lease
+// was renewed within three shard intervals.
+func shardMembers(kapi kubernetes.Interface) ([]string, error) {
+	ctx, cancel := apiContext()
+	defer cancel()
+	l, err := kapi.CoordinationV1().Leases(shardNamespace).List(ctx, metav1.ListOptions{LabelSelector: "kubernoisy-shard-group=" + shardGroup})
+	if err != nil {
+		return nil, err
+	}
+	var members []string
+	for _, lease := range l.Items {
+		if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil {
+			continue
+		}
+		if clock.Since(lease.Spec.RenewTime.Time) > 3*shardInterval {
+			continue
+		}
+		members = append(members, *lease.Spec.HolderIdentity)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// shardsConfigMap returns the name of the ConfigMap of the assignments.
+func shardsConfigMap() string {
+	return shardGroup + "-shards"
+}
+
+// assignShards writes, every shard interval while leading, the members of the
+// group and the share of the operations of each, splitting the operations of
+// the leader evenly.
+func assignShards(ctx context.Context, kapi kubernetes.Interface) {
+	cms := kapi.CoreV1().ConfigMaps(shardNamespace)
+	ticker := clock.NewTicker(shardInterval)
+	defer ticker.Stop()
+	last := ""
+	for {
+		if members, err := shardMembers(kapi); err != nil {
+			logEvent(logFields{action: "shard", object: "lease", namespace: shardNamespace, err: err}, "could not list shard members: %v", err)
+		} else if len(members) > 0 {
+			data := map[string]string{
+				"members": strings.Join(members, ","),
+				"ops":     strconv.FormatFloat(ops/float64(len(members)), 'f', -1, 64),
+			}
+			if err := writeShards(cms, data); err != nil {
+				logEvent(logFields{action: "shard", object: "configmap", name: shardsConfigMap(), namespace: shardNamespace, err: err}, "could not write shard assignments %v.%v: %v", shardsConfigMap(), shardNamespace, err)
+			} else if data["members"] != last {
+				last = data["members"]
+				logEvent(logFields{action: "shard", object: "configmap", name: shardsConfigMap(), namespace: shardNamespace}, "Assigned %v operations per second to each of %d shards", data["ops"], len(members))
+			}
+		}
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// writeShards creates or updates the ConfigMap of the assignments with data.
+func writeShards(cms corev1client.ConfigMapInterface, data map[string]string) error {
+	ctx, cancel := apiContext()
+	defer cancel()
+	cm, err := cms.Get(ctx, shardsConfigMap(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = cms.Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: shardsConfigMap(), Namespace: shardNamespace},
+			Data:       data,
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	cm.Data = data
+	_, err = cms.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// followShards reads the assignments every shard interval, setting the rate
+// of the shard to its share, or idling it if not a member.
+func followShards(kapi kubernetes.Interface) {
+	ticker := clock.NewTicker(shardInterval)
+	defer ticker.Stop()
+	share := 0.0
+	for {
+		ctx, cancel := apiContext()
+		cm, err := kapi.CoreV1().ConfigMaps(shardNamespace).Get(ctx, shardsConfigMap(), metav1.GetOptions{})
+		cancel()
+		if err != nil && !errors.IsNotFound(err) {
+			logEvent(logFields{action: "shard", object: "configmap", name: shardsConfigMap(), namespace: shardNamespace, err: err}, "could not read shard assignments %v.%v: %v", shardsConfigMap(), shardNamespace, err)
+		}
+		if err == nil {
+			next := 0.0
+			for _, m := range strings.Split(cm.Data["members"], ",") {
+				if m == shardID {
+					next, _ = strconv.ParseFloat(cm.Data["ops"], 64)
+				}
+			}
+			if next != share {
+				share = next
+				if share <= 0 {
+					atomic.StoreInt32(&shardIdle, 1)
+					logEvent(logFields{action: "shard"}, "Shard %v has no assignment, idling", shardID)
+				} else {
+					select {
+					case rateChanges <- share:
+					case <-opsCtx.Done():
+						return
+					}
+					atomic.StoreInt32(&shardIdle, 0)
+				}
+			}
+		}
+		select {
+		case <-ticker.C():
+		case <-opsCtx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// snapshotMetrics appends the current values of the kubernoisy metrics to the
+// snapshot file at every snapshot interval.
+func snapshotMetrics() {
+	ticker := clock.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		writeSnapshot()
+	}
+}
+
+// writeSnapshot appends a row to the snapshot file for each kubernoisy metric
+// series: the time, metric name, labels and value. Histograms are written as
+// their _count and _sum series.
+func writeSnapshot() {
+	families, err := gatherer.Gather()
+	if err != nil {
+		logEvent(logFields{action: "snapshot", err: err}, "could not gather metrics: %v", err)
+		return
+	}
+	f, err := os.OpenFile(snapshotFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logEvent(logFields{action: "snapshot", err: err}, "could not open metrics snapshot file %v: %v", snapshotFile, err)
+		return
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		w.Write([]string{"time", "metric", "labels", "value"})
+	}
+	now := clock.Now().UTC().Format(time.RFC3339)
+	for _, mf := range families {
+		name := mf.GetName()
+		if !strings.HasPrefix(name, "kubernoisy_") {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := snapshotLabels(m)
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				w.Write([]string{now, name, labels, formatValue(m.GetCounter().GetValue())})
+			case dto.MetricType_GAUGE:
+				w.Write([]string{now, name, labels, formatValue(m.GetGauge().GetValue())})
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				w.Write([]string{now, name + "_count", labels, strconv.FormatUint(h.GetSampleCount(), 10)})
+				w.Write([]string{now, name + "_sum", labels, formatValue(h.GetSampleSum())})
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		logEvent(logFields{action: "snapshot", err: err}, "could not write metrics snapshot to %v: %v", snapshotFile, err)
+	}
+}
+
+// snapshotLabels returns the labels of m as sorted name=value pairs separated by spaces.
+func snapshotLabels(m *dto.Metric) string {
+	pairs := make([]string, len(m.GetLabel()))
+	for i, l := range m.GetLabel() {
+		pairs[i] = l.GetName() + "=" + l.GetValue()
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// formatValue formats a metric value in its shortest exact form.
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// A standingService is a service of the standing population, left in place
+// for the whole run.
+type standingService struct {
+	ns, name string
+}
+
+// standingPrefix prefixes the names of the standing services.
+const standingPrefix = "kubernoisy-standing-"
+
+// createStanding creates the standing population of ClusterIP services,
+// spread across the namespaces, and waits for them to resolve. It returns the
+// services that were created.
+func createStanding(kapi kubernetes.Interface, n int) []standingService {
+	var standing []standingService
+	var queries []query
+	for i := 0; i < n; i++ {
+		s := standingService{ns: pickNamespace(), name: standingPrefix + RandStringBytes(10)}
+		svc := newService(s.ns, s.name)
+		// a cluster ip resolves without pods, keeping a large population cheap
+		svc.Spec.ClusterIP = ""
+		svc.Spec.Selector = nil
+		ctx, cancel := apiContext()
+		_, err := createServiceObject(ctx, kapi, svc)
+		cancel()
+		if err != nil {
+			recordCreateRejected("services", err)
+			logEvent(logFields{action: "add", object: "service", name: s.name, namespace: s.ns, err: err}, "could not create standing service %v.%v: %v", s.name, s.ns, err)
+			continue
+		}
+		recordOperation(s.ns, "service", "add")
+		standing = append(standing, s)
+		queries = append(queries, query{rtype: "IP", name: serviceHost(s.ns, s.name)})
+	}
+	StandingServices.Set(float64(len(standing)))
+
+	start := clock.Now()
+	results := verifyQueries("standing-add", queries, true)
+	verified := 0
+	for _, r := range results {
+		if r.verified {
+			verified++
+		}
+	}
+	elapsed := clock.Since(start)
+	logEvent(logFields{action: "standing-add", elapsed: elapsed}, "Created %d standing services, %d resolved within %v", len(standing), verified, elapsed)
+	return standing
+}
+
+// sampleStanding resolves a service of the standing population chosen at
+// random at every interval, recording the latency of steady state queries
+// while other services churn.
+func sampleStanding(standing []standingService, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		s := standing[rand.Intn(len(standing))]
+		go sampleStandingService(s)
+	}
+}
+
+// sampleStandingService resolves the standing service s once, recording the
+// lookup latency.
+func sampleStandingService(s standingService) {
+	start := clock.Now()
+	answers, err := lookup(query{rtype: "IP", name: serviceHost(s.ns, s.name)})
+	elapsed := clock.Since(start)
+	resolved := err == nil && len(answers) > 0
+	if resolved {
+		StandingLookupDuration.Observe(elapsed.Seconds())
+	} else {
+		debugEvent(logFields{action: "standing", object: "service", name: s.name, namespace: s.ns, elapsed: elapsed, err: err}, "standing service %v.%v did not resolve: %v", s.name, s.ns, err)
+	}
+	recordValidation("standing", s.ns, "IP", resolved, elapsed)
+}
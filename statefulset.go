@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// statefulSetCycle creates a StatefulSet of replicas pods and its governing
+// headless service, and verifies the hostname record of each ordinal pod
+// appears in DNS, then deletes them and verifies each record is removed,
+// recording the delays by ordinal.
+func statefulSetCycle(kapi kubernetes.Interface, w *workload, b *batch) bool {
+	// generate unique name
+	rando := namePrefix + RandStringBytes(18)
+	defer startTrace(rando)()
+	defer bindWorkload(rando, w)()
+	ns := pickNamespace()
+
+	cleanup := func() {
+		deleteStatefulSet(kapi, ns, rando)
+		deleteService(kapi, ns, rando)
+	}
+
+	if !createService(kapi, ns, rando) || !createStatefulSet(kapi, ns, rando) {
+		return failCycle("create", cleanup)
+	}
+
+	var queries []query
+	if verifySampled() {
+		queries = ordinalQueries(ns, rando)
+		// verify via DNS in loop with timeout
+		results := verifyQueries("add", queries, true)
+		recordOrdinals("add", results)
+		if !allVerified(results) {
+			return failCycle("verify", cleanup)
+		}
+		slowest := results[0].elapsed
+		for _, r := range results {
+			if r.elapsed > slowest {
+				slowest = r.elapsed
+			}
+		}
+		b.observe(slowest)
+	}
+
+	if !deleteStatefulSet(kapi, ns, rando) || !deleteService(kapi, ns, rando) {
+		return failCycle("delete", nil)
+	}
+
+	// verify via DNS in loop with timeout
+	recordOrdinals("delete", verifyQueries("delete", queries, false))
+	return true
+}
+
+// ordinalQueries returns a query of the hostname record of each ordinal pod
+// of the StatefulSet name, under its governing service.
+func ordinalQueries(ns, name string) []query {
+	queries := make([]query, replicas)
+	for i := range queries {
+		queries[i] = query{rtype: "IP", name: name + "-" + strconv.Itoa(i) + "." + serviceHost(ns, name)}
+	}
+	return queries
+}
+
+// recordOrdinals records the delays of the verified hostname records of the
+// ordinal pods under action.
+func recordOrdinals(action string, results []verification) {
+	for i, r := range results {
+		if r.verified {
+			OrdinalValidationDuration.WithLabelValues(action, strconv.Itoa(i)).Observe(r.elapsed.Seconds())
+		}
+	}
+}
+
+// newStatefulSet returns a StatefulSet of replicas pods selected by, and
+// governed by, the service name, with the pod spec of other modes.
+func newStatefulSet(ns, name string) *appsv1.StatefulSet {
+	pod := newPod(ns, name, 0)
+	// the controller sets the hostname of each pod to its name
+	pod.Spec.Hostname = ""
+	n := int32(replicas)
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    workloadLabels(name, map[string]string{"kubernoisy": "noise"}),
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:            &n,
+			ServiceName:         name,
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			Selector:            &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: pod.Labels},
+				Spec:       pod.Spec,
+			},
+		},
+	}
+}
+
+// createStatefulSet creates the StatefulSet name, returning false if it could
+// not be created.
+func createStatefulSet(kapi kubernetes.Interface, ns, name string) bool {
+	ctx, cancel := apiContext()
+	defer cancel()
+	_, err := kapi.AppsV1().StatefulSets(ns).Create(ctx, newStatefulSet(ns, name), metav1.CreateOptions{})
+	if err != nil {
+		logEvent(logFields{action: "add", object: "statefulset", name: name, namespace: ns, err: err}, "could not create statefulset %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "statefulset", "add")
+	return true
+}
+
+// deleteStatefulSet deletes the StatefulSet name and its pods, returning false
+// if it could not be deleted.
+func deleteStatefulSet(kapi kubernetes.Interface, ns, name string) bool {
+	propagation := metav1.DeletePropagationBackground
+	ctx, cancel := apiContext()
+	defer cancel()
+	err := kapi.AppsV1().StatefulSets(ns).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil {
+		debugEvent(logFields{action: "delete", object: "statefulset", name: name, namespace: ns, err: err}, "could not delete statefulset %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "statefulset", "delete")
+	return true
+}
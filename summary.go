@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reservoir is a fixed size uniform random sample of a stream of values
+// (Vitter's algorithm R), so that quantiles of an arbitrarily long run can be
+// estimated in bounded memory. For a reservoir of k samples the standard error
+// of the rank of an estimated q-quantile is about sqrt(q*(1-q)/k), e.g. ±0.1%
+// at p99 for the default of 10000 samples.
+type reservoir struct {
+	mu      sync.Mutex
+	size    int
+	count   int64
+	samples []float64
+}
+
+func newReservoir(size int) *reservoir {
+	return &reservoir{size: size, samples: make([]float64, 0, size)}
+}
+
+// Add offers v to the reservoir.
+func (r *reservoir) Add(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	if len(r.samples) < r.size {
+		r.samples = append(r.samples, v)
+		return
+	}
+	if i := rand.Int63n(r.count); i < int64(r.size) {
+		r.samples[i] = v
+	}
+}
+
+// Quantiles returns the estimated value of each quantile in qs, and the
+// number of values offered to the reservoir.
+func (r *reservoir) Quantiles(qs ...float64) ([]float64, int64) {
+	if len(qs) == 0 {
+		// nothing to sort for
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return nil, r.count
+	}
+	r.mu.Lock()
+	sorted := append([]float64(nil), r.samples...)
+	count := r.count
+	r.mu.Unlock()
+
+	sort.Float64s(sorted)
+	vals := make([]float64, len(qs))
+	if len(sorted) == 0 {
+		return vals, count
+	}
+	for i, q := range qs {
+		vals[i] = sorted[int(q*float64(len(sorted)-1))]
+	}
+	return vals, count
+}
+
+var (
+	samplesMu sync.Mutex
+	samples   = make(map[string]*reservoir)
+	failures  = make(map[string]int64)
+)
+
+// sampleLatency adds a validation latency for action to the summary samples.
+func sampleLatency(action string, d time.Duration) {
+	samplesMu.Lock()
+	r, ok := samples[action]
+	if !ok {
+		r = newReservoir(sampleReservoir)
+		samples[action] = r
+	}
+	samplesMu.Unlock()
+	r.Add(d.Seconds())
+}
+
+// sampleFailure counts a failed validation for action in the summary.
+func sampleFailure(action string) {
+	samplesMu.Lock()
+	failures[action]++
+	samplesMu.Unlock()
+}
+
+// resetSummary discards the summary samples and failures.
+func resetSummary() {
+	samplesMu.Lock()
+	samples = make(map[string]*reservoir)
+	failures = make(map[string]int64)
+	samplesMu.Unlock()
+}
+
+// logSummary logs the validation latency percentiles of each action, giving
+// up on the remaining actions after the summary timeout so that shutdown
+// stays responsive.
+func logSummary() {
+	start := clock.Now()
+	samplesMu.Lock()
+	defer samplesMu.Unlock()
+	done := 0
+	for action, r := range samples {
+		if summaryTimeout > 0 && clock.Since(start) > summaryTimeout {
+			logEvent(logFields{action: "summary"}, "Summary timed out after %v, skipping %d actions", summaryTimeout, len(samples)-done)
+			break
+		}
+		q, n := r.Quantiles(summaryQuantiles...)
+		logEvent(logFields{action: "summary"}, "Validation %v: %d verified%v", action, n, formatQuantiles(q))
+		done++
+	}
+	logEvent(logFields{action: "summary"}, "Summary took %v", clock.Since(start))
+}
+
+// formatQuantiles returns the values q of the summary quantiles as ", pNN
+// value" for each, e.g. ", p50 0.500s, p99 1.000s".
+func formatQuantiles(q []float64) string {
+	s := ""
+	for i, v := range q {
+		s += fmt.Sprintf(", p%v %.3fs", strconv.FormatFloat(summaryQuantiles[i]*100, 'f', -1, 64), v)
+	}
+	return s
+}
+
+// parseQuantiles parses a comma separated list of quantiles between 0 and 1.
+func parseQuantiles(s string) ([]float64, error) {
+	var qs []float64
+	if s == "" {
+		return qs, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil || q < 0 || q > 1 {
+			return nil, fmt.Errorf("invalid quantile %q", f)
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
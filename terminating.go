@@ -0,0 +1,45 @@
+package main
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// verifyTerminating gracefully deletes the first pod of the service name and
+// polls host until the addresses of that pod are no longer answered, up to the
+// jittered timeout, recording the time under the terminating action. Endpoints
+// drop a pod once it is terminating, before it is gone, so with other replicas
+// the service keeps resolving.
+func verifyTerminating(kapi kubernetes.Interface, ns, name, host string) bool {
+	pod := podName(name, 0)
+	ips, err := waitPodIPs(kapi, ns, pod)
+	if err != nil {
+		logEvent(logFields{action: "terminating", object: "pod", name: pod, namespace: ns, err: err}, "could not get ips of pod %v.%v: %v", pod, ns, err)
+		return false
+	}
+	start := clock.Now()
+	ctx, cancel := apiContext()
+	defer cancel()
+	if err := kapi.CoreV1().Pods(ns).Delete(ctx, pod, metav1.DeleteOptions{}); err != nil {
+		logEvent(logFields{action: "delete", object: "pod", name: pod, namespace: ns, err: err}, "could not delete pod %v.%v: %v", pod, ns, err)
+		return false
+	}
+	recordOperation(ns, "pod", "delete")
+
+	q := query{rtype: "IP", name: host}
+	timeout := jitteredTimeout(q)
+	for clock.Since(start) < timeout {
+		answers, err := lookup(q)
+		if (err == nil || notFound(err)) && !anyAnswer(answers, ips) {
+			recordValidation("terminating", ns, q.rtype, true, clock.Since(start))
+			return true
+		}
+		if noRetryVerify || abandoned() {
+			break
+		}
+		clock.Sleep(pollInterval)
+	}
+	debugEvent(logFields{action: "terminating", object: "pod", name: pod, namespace: ns}, "%v still answered with terminating pod %v ips %v", host, pod, ips)
+	recordValidation("terminating", ns, q.rtype, false, clock.Since(start))
+	return false
+}
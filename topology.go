@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	zoneLabel           = "topology.kubernetes.io/zone"
+	zoneLabelDeprecated = "failure-domain.beta.kubernetes.io/zone"
+
+	topologyHintsAnnotation = "service.kubernetes.io/topology-aware-hints"
+)
+
+// nodeZones caches the zone of each node by name.
+var nodeZones sync.Map
+
+// pinNodes are the nodes to pin pods to in turn, parsed from the verify-nodes
+// flag, and nodePick counts the nodes picked.
+var (
+	pinNodes []string
+	nodePick uint64
+)
+
+// parseNodes parses a comma separated list of distinct node names.
+func parseNodes(s string) ([]string, error) {
+	var nodes []string
+	seen := make(map[string]bool)
+	for _, n := range strings.Split(s, ",") {
+		n = strings.TrimSpace(n)
+		if errs := validation.IsDNS1123Subdomain(n); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid node %q: %v", n, strings.Join(errs, "; "))
+		}
+		if seen[n] {
+			return nil, fmt.Errorf("node %q is listed twice", n)
+		}
+		seen[n] = true
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// pickNode returns the node to pin a new pod to, in turn, or "" to leave
+// scheduling to the scheduler.
+func pickNode() string {
+	if len(pinNodes) == 0 {
+		return ""
+	}
+	return pinNodes[(atomic.AddUint64(&nodePick, 1)-1)%uint64(len(pinNodes))]
+}
+
+// checkNodes returns an error unless each of the nodes exists, since pods
+// pinned to a missing node would never start.
+func checkNodes(kapi kubernetes.Interface, nodes []string) error {
+	for _, n := range nodes {
+		ctx, cancel := apiContext()
+		_, err := kapi.CoreV1().Nodes().Get(ctx, n, metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("could not get node %v: %v", n, err)
+		}
+	}
+	return nil
+}
+
+// nodeZone returns the zone of the node, or "unknown" if it cannot be determined.
+func nodeZone(kapi kubernetes.Interface, node string) string {
+	if node == "" {
+		return "unknown"
+	}
+	if z, ok := nodeZones.Load(node); ok {
+		return z.(string)
+	}
+	ctx, cancel := apiContext()
+	defer cancel()
+	n, err := kapi.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+	if err != nil {
+		debugEvent(logFields{object: "node", name: node, err: err}, "could not get node %v: %v", node, err)
+		return "unknown"
+	}
+	zone := n.Labels[zoneLabel]
+	if zone == "" {
+		zone = n.Labels[zoneLabelDeprecated]
+	}
+	if zone == "" {
+		zone = "unknown"
+	}
+	nodeZones.Store(node, zone)
+	return zone
+}
+
+// recordZoneAnswers records the zone of each answered endpoint of the service
+// name, of any of the addresses of its pod, relative to the zone the query was
+// made from. When the client zone has
+// ready endpoints of its own, topology aware routing should keep answers
+// zone-local, so non-local answers are counted as locality failures.
+func recordZoneAnswers(kapi kubernetes.Interface, ns, name string, ips []string) {
+	clientZone := "unknown"
+	if execPod != nil {
+		clientZone = execPod.zone
+	}
+
+	ctx, cancel := apiContext()
+	defer cancel()
+	pods, err := kapi.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: "app=" + name})
+	if err != nil {
+		debugEvent(logFields{object: "pod", name: name, namespace: ns, err: err}, "could not list pods of %v.%v: %v", name, ns, err)
+		return
+	}
+	zones := make(map[string]string)
+	local := false
+	for _, p := range pods.Items {
+		zone := nodeZone(kapi, p.Spec.NodeName)
+		zones[p.Status.PodIP] = zone
+		for _, ip := range p.Status.PodIPs {
+			zones[ip.IP] = zone
+		}
+		if zone == clientZone && podReady(&p) {
+			local = true
+		}
+	}
+
+	nonLocal := 0
+	for _, ip := range ips {
+		zone, ok := zones[ip]
+		if !ok {
+			zone = "unknown"
+		}
+		if zone != clientZone {
+			nonLocal++
+		}
+		ZoneAnswerCount.WithLabelValues(clientZone, zone).Inc()
+	}
+	if local && nonLocal > 0 {
+		ZoneLocalityFailCount.Inc()
+		debugEvent(logFields{action: "topology", object: "service", name: name, namespace: ns}, "%v of %v answers for %v.%v are outside zone %v", nonLocal, len(ips), name, ns, clientZone)
+	}
+}
+
+// recordPodNodes counts the node each pod of the service name is scheduled
+// on, returning the node of each pod by name.
+func recordPodNodes(kapi kubernetes.Interface, ns, name string) map[string]string {
+	ctx, cancel := apiContext()
+	defer cancel()
+	pods, err := kapi.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: "app=" + name})
+	if err != nil {
+		debugEvent(logFields{object: "pod", name: name, namespace: ns, err: err}, "could not list pods of %v.%v: %v", name, ns, err)
+		return nil
+	}
+	nodes := make(map[string]string)
+	for _, p := range pods.Items {
+		node := p.Spec.NodeName
+		if node == "" {
+			node = "unknown"
+		}
+		nodes[p.Name] = node
+		PodsPerNode.WithLabelValues(node).Inc()
+	}
+	return nodes
+}
+
+// recordNodeValidation records the add propagation delay of a record of a
+// single pod by the node the pod is on.
+func recordNodeValidation(node string, elapsed time.Duration) {
+	if node != "" {
+		NodeValidationDuration.WithLabelValues(node).Observe(elapsed.Seconds())
+	}
+}
+
+// podReady returns true if the pod has a true Ready condition.
+func podReady(p *v1.Pod) bool {
+	for _, c := range p.Status.Conditions {
+		if c.Type == v1.PodReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecordZoneAnswersDualStack(t *testing.T) {
+	prevExecPod := execPod
+	execPod = &execResolver{zone: "zone-a"}
+	defer func() { execPod = prevExecPod }()
+
+	ns, name := "ns", "kubernoisy-zones"
+	kapi := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{zoneLabel: "zone-a"}}},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name + "-0", Namespace: ns, Labels: map[string]string{"app": name}},
+			Spec:       v1.PodSpec{NodeName: "node-a"},
+			Status: v1.PodStatus{
+				PodIP:      "10.0.0.1",
+				PodIPs:     []v1.PodIP{{IP: "10.0.0.1"}, {IP: "fd00::1"}},
+				Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+			},
+		},
+	)
+
+	local := ZoneAnswerCount.WithLabelValues("zone-a", "zone-a")
+	unknown := ZoneAnswerCount.WithLabelValues("zone-a", "unknown")
+	prevLocal, prevUnknown, prevFail := testutil.ToFloat64(local), testutil.ToFloat64(unknown), testutil.ToFloat64(ZoneLocalityFailCount)
+	recordZoneAnswers(kapi, ns, name, []string{"10.0.0.1", "fd00::1"})
+	if got := testutil.ToFloat64(local) - prevLocal; got != 2 {
+		t.Errorf("zone-local answers = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(unknown) - prevUnknown; got != 0 {
+		t.Errorf("answers of unknown zone = %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(ZoneLocalityFailCount) - prevFail; got != 0 {
+		t.Errorf("locality failures = %v, want 0", got)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the spans of the operations, a no-op until tracing is set
+// up.
+var tracer = otel.Tracer("kubernoisy")
+
+// tracerProvider exports the spans over OTLP, nil if tracing is disabled.
+var tracerProvider *sdktrace.TracerProvider
+
+// opSpans are the contexts of the spans of the cycles in progress, by the
+// name of their objects.
+var opSpans sync.Map
+
+// setupTracing exports the spans of the operations to the OTLP/HTTP collector
+// at the otlp endpoint, sampling the operations at the trace sample ratio.
+func setupTracing() error {
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(otlpEndpoint)}
+	if otlpInsecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return err
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("kubernoisy"), attribute.String("kubernoisy.cluster", clusterName))
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(traceSampleRatio))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	tracer = tracerProvider.Tracer("kubernoisy")
+	logEvent(logFields{action: "trace"}, "Exporting traces to %v, sampling %v of operations", otlpEndpoint, traceSampleRatio)
+	return nil
+}
+
+// shutdownTracing flushes the spans not yet exported.
+func shutdownTracing() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		logEvent(logFields{action: "trace", err: err}, "could not flush traces to %v: %v", otlpEndpoint, err)
+	}
+}
+
+// startOpSpan starts the span of the operation of the cycle name, parent of the
+// spans of its steps, and returns its trace id and the function ending it.
+func startOpSpan(name string) (string, func()) {
+	ctx, span := tracer.Start(context.Background(), "operation", trace.WithAttributes(attribute.String("kubernoisy.name", name)))
+	opSpans.Store(name, ctx)
+	return span.SpanContext().TraceID().String(), func() {
+		opSpans.Delete(name)
+		span.End()
+	}
+}
+
+// startSpan starts the span of the step op of the operation of the object or
+// host name, or returns a no-op span outside of an operation, e.g. for the
+// cleanup on exit.
+func startSpan(name, op string, attrs ...attribute.KeyValue) trace.Span {
+	v, ok := loadByName(&opSpans, name)
+	if !ok {
+		return trace.SpanFromContext(context.Background())
+	}
+	_, span := tracer.Start(v.(context.Context), op, trace.WithAttributes(attrs...))
+	return span
+}
+
+// endSpan ends span, marking it failed with err if not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
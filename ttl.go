@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ttlServer returns the address of the nameserver to query for ttls: that of
+// the custom resolver if any, otherwise the first in resolv.conf.
+func ttlServer() (string, error) {
+	if resolverServer != "" {
+		return resolverServer, nil
+	}
+	conf, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	servers := parseNameservers(string(conf))
+	if len(servers) == 0 {
+		return "", fmt.Errorf("no nameserver in resolv.conf")
+	}
+	return net.JoinHostPort(servers[0], "53"), nil
+}
+
+// queryTTL returns the minimum ttl of the A, or failing that AAAA, records of fqdn.
+func queryTTL(fqdn string) (time.Duration, error) {
+	server, err := ttlServer()
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		ttl, ok, err := queryTypeTTL(server, fqdn, t)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return ttl, nil
+		}
+	}
+	return 0, fmt.Errorf("no answers")
+}
+
+// queryTypeTTL queries server for the records of type t of fqdn, returning
+// the minimum ttl of the answers, if any.
+func queryTypeTTL(server, fqdn string, t dnsmessage.Type) (time.Duration, bool, error) {
+	name, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return 0, false, err
+	}
+	id := uint16(time.Now().UnixNano())
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return 0, false, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: t, Class: dnsmessage.ClassINET}); err != nil {
+		return 0, false, err
+	}
+	req, err := b.Finish()
+	if err != nil {
+		return 0, false, err
+	}
+
+	conn, err := net.DialTimeout("udp", server, 5*time.Second)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(req); err != nil {
+		return 0, false, err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var p dnsmessage.Parser
+	h, err := p.Start(buf[:n])
+	if err != nil {
+		return 0, false, err
+	}
+	if h.ID != id {
+		return 0, false, fmt.Errorf("mismatched response id")
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return 0, false, err
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return 0, false, err
+	}
+	found := false
+	var min uint32
+	for _, a := range answers {
+		if a.Header.Type != t {
+			continue
+		}
+		if !found || a.Header.TTL < min {
+			min = a.Header.TTL
+		}
+		found = true
+	}
+	return time.Duration(min) * time.Second, found, nil
+}
+
+// recordDeleteTTL records how the delete propagation compares with the ttl
+// of the deleted record, distinguishing teardown bound by cache expiry from
+// faster authoritative teardown.
+func recordDeleteTTL(elapsed, ttl time.Duration) {
+	DeleteTTLRatio.Observe(elapsed.Seconds() / ttl.Seconds())
+	DeleteTTLDifference.Observe((elapsed - ttl).Seconds())
+}
+
+// ttlExpiryMargin is how long past the ttl of a record to wait for it to expire from caches.
+const ttlExpiryMargin = 500 * time.Millisecond
+
+// checkTTLExpiry resolves fqdn while its record is cached, then again just
+// past its remaining ttl, recording the latency of both lookups. A resolver
+// honouring the ttl must look the record up afresh once it expires, which
+// should take longer than answering from cache, so an expired lookup that is
+// no slower is counted as the ttl being ignored.
+func checkTTLExpiry(fqdn string, ttl time.Duration) {
+	warm, err := timedLookup(fqdn)
+	if err != nil {
+		debugEvent(logFields{action: "ttl", trace: traceOf(fqdn), err: err}, "could not resolve %v: %v", fqdn, err)
+		return
+	}
+	clock.Sleep(ttl + ttlExpiryMargin)
+	expired, err := timedLookup(fqdn)
+	if err != nil {
+		debugEvent(logFields{action: "ttl", trace: traceOf(fqdn), err: err}, "could not resolve %v: %v", fqdn, err)
+		return
+	}
+	TTLLookupDuration.WithLabelValues("warm").Observe(warm.Seconds())
+	TTLLookupDuration.WithLabelValues("expired").Observe(expired.Seconds())
+	if expired <= warm {
+		TTLIgnoredCount.Inc()
+		debugEvent(logFields{action: "ttl", trace: traceOf(fqdn)}, "lookup of %v after its %v ttl took %v, no longer than the cached lookup of %v", fqdn, ttl, expired, warm)
+	}
+}
+
+// timedLookup returns the duration of resolving fqdn with the configured resolver.
+func timedLookup(fqdn string) (time.Duration, error) {
+	start := clock.Now()
+	_, err := lookup(query{rtype: "IP", name: fqdn})
+	return clock.Since(start), err
+}
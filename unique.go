@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+)
+
+// liveIPs tracks the addresses that live objects resolved to, to detect two
+// live objects resolving to the same address.
+var liveIPs = struct {
+	sync.Mutex
+	owners map[string]string   // address to object name
+	claims map[string][]string // object name to addresses
+}{owners: make(map[string]string), claims: make(map[string][]string)}
+
+// claimIPs records that the object name resolved to ips, counting addresses
+// already claimed by another live object as collisions.
+func claimIPs(name string, ips []string) {
+	liveIPs.Lock()
+	defer liveIPs.Unlock()
+	for _, ip := range ips {
+		if owner, ok := liveIPs.owners[ip]; ok && owner != name {
+			IPCollisionCount.Inc()
+			logEvent(logFields{action: "collision", trace: traceOf(name)}, "%v resolved to %v, which %v also resolves to", name, ip, owner)
+			continue
+		}
+		liveIPs.owners[ip] = name
+		liveIPs.claims[name] = append(liveIPs.claims[name], ip)
+	}
+}
+
+// releaseIPs releases the addresses claimed by the object name.
+func releaseIPs(name string) {
+	liveIPs.Lock()
+	defer liveIPs.Unlock()
+	for _, ip := range liveIPs.claims[name] {
+		delete(liveIPs.owners, ip)
+	}
+	delete(liveIPs.claims, name)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// verifyUpdate flips the selector of the service name from its pods to a spare
+// pod and back, recording under the update action the time for host to answer
+// with only the addresses of the newly selected pods each time. It deletes the
+// spare pod when done.
+func verifyUpdate(kapi kubernetes.Interface, ns, name, host string) bool {
+	spare := name + "-update"
+	pod := newPod(ns, name, 0)
+	pod.Name = spare
+	pod.Labels["app"] = spare
+	ctx, cancel := apiContext()
+	_, err := kapi.CoreV1().Pods(ns).Create(ctx, pod, metav1.CreateOptions{})
+	cancel()
+	if err != nil {
+		logEvent(logFields{action: "add", object: "pod", name: spare, namespace: ns, err: err}, "could not create pod %v.%v: %v", spare, ns, err)
+		return false
+	}
+	recordOperation(ns, "pod", "add")
+	defer func() {
+		ctx, cancel := apiContext()
+		defer cancel()
+		if err := kapi.CoreV1().Pods(ns).Delete(ctx, spare, metav1.DeleteOptions{}); err != nil {
+			debugEvent(logFields{action: "delete", object: "pod", name: spare, namespace: ns, err: err}, "could not delete pod %v.%v: %v", spare, ns, err)
+			return
+		}
+		recordOperation(ns, "pod", "delete")
+	}()
+
+	ips, err := servicePodIPs(kapi, ns, name)
+	if err != nil {
+		logEvent(logFields{action: "update", object: "pod", name: name, namespace: ns, err: err}, "could not get ips of pods of %v.%v: %v", name, ns, err)
+		return false
+	}
+	spareIPs, err := waitPodIPs(kapi, ns, spare)
+	if err != nil {
+		logEvent(logFields{action: "update", object: "pod", name: spare, namespace: ns, err: err}, "could not get ips of pod %v.%v: %v", spare, ns, err)
+		return false
+	}
+	return flipSelector(kapi, ns, name, host, spare, spareIPs) && flipSelector(kapi, ns, name, host, name, ips)
+}
+
+// flipSelector sets the selector of the service name to the pods of app, and
+// polls host until it answers with exactly ips, up to the jittered timeout.
+func flipSelector(kapi kubernetes.Interface, ns, name, host, app string, ips []string) bool {
+	patch := fmt.Sprintf(`{"spec":{"selector":{"app":%q}}}`, app)
+	start := clock.Now()
+	ctx, cancel := apiContext()
+	defer cancel()
+	if _, err := kapi.CoreV1().Services(ns).Patch(ctx, name, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		logEvent(logFields{action: "update", object: "service", name: name, namespace: ns, err: err}, "could not update service %v.%v: %v", name, ns, err)
+		return false
+	}
+	recordOperation(ns, "service", "update")
+
+	q := query{rtype: "IP", name: host}
+	timeout := jitteredTimeout(q)
+	for clock.Since(start) < timeout {
+		answers, err := lookup(q)
+		if err == nil && sameAnswers(answers, ips) {
+			recordValidation("update", ns, q.rtype, true, clock.Since(start))
+			return true
+		}
+		if noRetryVerify || abandoned() {
+			break
+		}
+		clock.Sleep(pollInterval)
+	}
+	debugEvent(logFields{action: "update", object: "service", name: name, namespace: ns}, "%v did not answer with the ips %v of %v after updating its selector", host, ips, app)
+	recordValidation("update", ns, q.rtype, false, clock.Since(start))
+	return false
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// A dnsResolver performs verification lookups. It is satisfied by *net.Resolver.
+type dnsResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// recordTypes are the record types a query may look up.
+var recordTypes = map[string]bool{"IP": true, "A": true, "AAAA": true, "SRV": true, "PTR": true}
+
+// A query is a DNS lookup to verify.
+type query struct {
+	rtype string   // IP (any address), A, AAAA, SRV or PTR
+	name  string   // host name, or address for PTR
+	port  string   // port name of SRV, kubernoisy if empty
+	want  []string // exact answers to wait for when adding, if known
+	stale []string // answers of a deleted object, counted if answered when adding
+
+	timeout time.Duration // verification timeout, that of the workload of name if 0
+
+	action string // verification the lookups are timed for, if any
+}
+
+// lookup resolves q with the configured resolver.
+func lookup(q query) ([]string, error) {
+	return lookupWith(resolver, q)
+}
+
+// lookupWith resolves q with r, returning the answers as strings: addresses
+// for IP, A and AAAA, target:port for SRV and names for PTR. The lookup is
+// abandoned after the query timeout, if set.
+func lookupWith(r dnsResolver, q query) ([]string, error) {
+	if q.action != "" {
+		start := clock.Now()
+		answers, err := lookupWith(r, query{rtype: q.rtype, name: q.name, port: q.port})
+		outcome := "hit"
+		switch {
+		case err != nil && !notFound(err):
+			outcome = "error"
+		case len(answers) == 0:
+			outcome = "miss"
+		}
+		DNSQueryDuration.WithLabelValues(q.action, outcome).Observe(clock.Since(start).Seconds())
+		return answers, err
+	}
+	ctx := opsCtx
+	if queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, queryTimeout)
+		defer cancel()
+		defer func() {
+			if ctx.Err() == context.DeadlineExceeded {
+				QueryTimeoutCount.Inc()
+			}
+		}()
+	}
+	switch q.rtype {
+	case "SRV":
+		_, srvs, err := r.LookupSRV(ctx, srvPort(q), "tcp", q.name)
+		if err != nil {
+			return nil, err
+		}
+		answers := make([]string, len(srvs))
+		for i, s := range srvs {
+			answers[i] = net.JoinHostPort(s.Target, strconv.Itoa(int(s.Port)))
+		}
+		return answers, nil
+	case "PTR":
+		return r.LookupAddr(ctx, q.name)
+	}
+	addrs, err := lookupAddrs(ctx, r, q.name)
+	if err != nil {
+		return nil, err
+	}
+	var answers []string
+	for _, a := range addrs {
+		v4 := a.To4() != nil
+		if q.rtype == "IP" || (q.rtype == "A") == v4 {
+			answers = append(answers, a.String())
+		}
+	}
+	return answers, nil
+}
+
+// lookupAddrs resolves host to addresses with the selected lookup function.
+// LookupHost and LookupIP differ in details such as CNAME handling, so this
+// allows matching the behavior of other clients.
+func lookupAddrs(ctx context.Context, r dnsResolver, host string) ([]net.IP, error) {
+	if lookupFunc == "host" {
+		hosts, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var ips []net.IP
+		for _, h := range hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		return ips, nil
+	}
+	addrs, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, nil
+}
+
+// A namedResolver is a resolver of the required resolvers.
+type namedResolver struct {
+	name string
+	dnsResolver
+}
+
+// requiredMu guards the required resolvers, replaced as the replicas of the
+// DNS service are rediscovered.
+var requiredMu sync.RWMutex
+
+// setRequiredResolvers replaces the required resolvers with resolvers.
+func setRequiredResolvers(resolvers []namedResolver) {
+	requiredMu.Lock()
+	defer requiredMu.Unlock()
+	requiredResolvers = resolvers
+}
+
+// verifyResolvers returns the resolvers a query must be verified on: the
+// required resolvers if any, otherwise the configured resolver.
+func verifyResolvers() []namedResolver {
+	requiredMu.RLock()
+	defer requiredMu.RUnlock()
+	if len(requiredResolvers) > 0 {
+		return requiredResolvers
+	}
+	return []namedResolver{{"", resolver}}
+}
+
+// verifyPresent polls DNS until q resolves on every verify resolver, to
+// exactly its wanted answers if set, and keeps resolving for the minimum
+// verify duration, up to the jittered timeout, or only once when not
+// retrying. It returns the delay until q resolved stably and the answers of
+// the first resolver to converge, or if it did not resolve, whether it was last
+// answered but not with its wanted answers.
+func verifyPresent(q query) (bool, time.Duration, []string, bool) {
+	var elapsed time.Duration
+	var answers []string
+	pending := verifyResolvers()
+	timeout := jitteredTimeout(q)
+	stale := false
+	var staleFor time.Duration
+	defer func() {
+		if stale {
+			StaleAnswerCount.Inc()
+			StaleAnswerDuration.WithLabelValues(q.action).Observe(staleFor.Seconds())
+			logEvent(logFields{action: "add", name: q.name}, "%v answered with stale addresses %v", q.name, q.stale)
+		}
+	}()
+	mismatch := false
+	converged := make(map[string]bool)
+	var first, slowest time.Duration
+	// when q was first answered with any address, before all wanted were
+	partial := time.Duration(-1)
+	for start := clock.Now(); clock.Since(start) < timeout; {
+		var unconverged []namedResolver
+		var last string
+		mismatch = false
+		for _, r := range pending {
+			a, ok := presentOn(r, q)
+			if len(a) > 0 && partial < 0 {
+				partial = elapsed
+			}
+			if q.stale != nil && anyAnswer(a, q.stale) {
+				stale = true
+				staleFor = clock.Since(start)
+			}
+			if !ok {
+				if len(a) > 0 && (q.want != nil || q.rtype == "SRV") {
+					// answered, but not as wanted
+					mismatch = true
+				}
+				unconverged = append(unconverged, r)
+				continue
+			}
+			if answers == nil {
+				answers = a
+			}
+			if r.name != "" && !converged[r.name] {
+				// skew between the required resolvers
+				converged[r.name] = true
+				took := clock.Since(start)
+				ResolverValidationDuration.WithLabelValues(r.name).Observe(took.Seconds())
+				if len(converged) == 1 {
+					first = took
+				}
+				slowest = took
+			}
+			last = r.name
+		}
+		if len(unconverged) == 0 {
+			if stableFor(q, minVerifyDuration) {
+				recordLastConverged(last)
+				if len(converged) > 1 {
+					ResolverSkewDuration.Observe((slowest - first).Seconds())
+				}
+				if q.want != nil {
+					EndpointQuorumFraction.Observe(wantedFraction(answers, q.want))
+				}
+				if len(q.want) > 1 {
+					PartialPropagationDuration.WithLabelValues(q.action).Observe((elapsed - partial).Seconds())
+				}
+				return true, elapsed, answers, false
+			}
+			// e.g. a stale record of a prior object expiring, so wait for it to be present again
+			UnstableVerifyCount.Inc()
+			debugEvent(logFields{action: "add", name: q.name, elapsed: minVerifyDuration}, "%v was not present for %v", q.name, minVerifyDuration)
+			unconverged, answers = verifyResolvers(), nil
+		}
+		pending = unconverged
+		if noRetryVerify || abandoned() {
+			break
+		}
+		clock.Sleep(pollInterval)
+		elapsed = clock.Since(start)
+	}
+	return false, elapsed, nil, mismatch
+}
+
+// presentOn returns the answers of r for q, and whether they mean q is
+// present: any answers, or if wanted answers are set, exactly those, or at
+// least the endpoint quorum fraction of them.
+func presentOn(r dnsResolver, q query) ([]string, bool) {
+	a, err := lookupWith(r, q)
+	if err != nil || len(a) == 0 {
+		return a, false
+	}
+	if q.rtype == "SRV" && !srvMatches(q.name, portNumber(srvPort(q)), a) {
+		debugEvent(logFields{name: q.name}, "%v answered with srv records not of its service: %v", q.name, a)
+		return a, false
+	}
+	if q.want != nil {
+		if endpointQuorum >= 1 {
+			return a, sameAnswers(a, q.want)
+		}
+		return a, wantedFraction(a, q.want) >= endpointQuorum
+	}
+	return a, true
+}
+
+// srvPort returns the port name of the SRV query q.
+func srvPort(q query) string {
+	if q.port == "" {
+		return "kubernoisy"
+	}
+	return q.port
+}
+
+// fqdnOf returns the fully qualified name of the service host.
+func fqdnOf(host string) string {
+	if !strings.HasSuffix(host, ".") {
+		// the short name of mimic mode
+		return host + ".svc." + clusterDomain + "."
+	}
+	return host
+}
+
+// srvMatches returns true if each of the SRV answers, as target:port, is of
+// the service host: the port number of a target within the service domain.
+func srvMatches(host string, number int, answers []string) bool {
+	fqdn := fqdnOf(host)
+	for _, a := range answers {
+		target, port, err := net.SplitHostPort(a)
+		if err != nil || port != strconv.Itoa(number) || !strings.HasSuffix(target, "."+fqdn) {
+			return false
+		}
+	}
+	return true
+}
+
+// wantedFraction returns the fraction of want that are among answers.
+func wantedFraction(answers, want []string) float64 {
+	if len(want) == 0 {
+		return 1
+	}
+	answered := make(map[string]bool)
+	for _, a := range answers {
+		answered[a] = true
+	}
+	n := 0
+	for _, w := range want {
+		if answered[w] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(want))
+}
+
+// stableFor polls q on every verify resolver for d, returning false as soon as
+// it is not present on any of them.
+func stableFor(q query, d time.Duration) bool {
+	for start := clock.Now(); clock.Since(start) < d; {
+		wait := d - clock.Since(start)
+		if wait > pollInterval {
+			wait = pollInterval
+		}
+		clock.Sleep(wait)
+		for _, r := range verifyResolvers() {
+			if _, ok := presentOn(r, q); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// verifyAbsent polls DNS until q is answered as not found (NXDOMAIN, or no
+// records of its type) on every verify resolver, up to the jittered timeout,
+// or only once when not retrying. Other errors, e.g. SERVFAIL or timeouts, are
+// counted but do not verify it. It records for how long q was still answered
+// with records, and returns, if not verified, the reason: the error last
+// answered, or unverified if records were.
+func verifyAbsent(q query) (bool, time.Duration, string) {
+	var elapsed, staleFor time.Duration
+	pending := verifyResolvers()
+	timeout := jitteredTimeout(q)
+	reason := "unverified"
+	defer func() {
+		StaleServingDuration.WithLabelValues(q.action).Observe(staleFor.Seconds())
+	}()
+	for start := clock.Now(); clock.Since(start) < timeout; {
+		var unconverged []namedResolver
+		var last string
+		for _, r := range pending {
+			answers, err := lookupWith(r, q)
+			switch {
+			case notFound(err), err == nil && len(answers) == 0:
+				// NXDOMAIN, or NODATA: no records of its type
+				last = r.name
+				continue
+			case err != nil:
+				reason = lookupErrorKind(err)
+				DeleteLookupErrorCount.WithLabelValues(reason).Inc()
+			default:
+				// still served, e.g. from a cache
+				reason = "unverified"
+				staleFor = clock.Since(start)
+			}
+			unconverged = append(unconverged, r)
+		}
+		if len(unconverged) == 0 {
+			recordLastConverged(last)
+			return true, elapsed, ""
+		}
+		pending = unconverged
+		if noRetryVerify || abandoned() {
+			break
+		}
+		clock.Sleep(pollInterval)
+		elapsed = clock.Since(start)
+	}
+	return false, elapsed, reason
+}
+
+// lookupErrorKind returns the kind of the lookup error err, other than not
+// found: servfail, timeout or error.
+func lookupErrorKind(err error) string {
+	dnsErr, ok := err.(*net.DNSError)
+	switch {
+	case ok && dnsErr.IsTimeout:
+		return "timeout"
+	case ok && (dnsErr.IsTemporary || strings.Contains(dnsErr.Err, "server misbehaving") || strings.Contains(dnsErr.Err, "SERVFAIL")):
+		return "servfail"
+	}
+	return "error"
+}
+
+// queryNamespace returns the namespace of the service or pod name queried, the
+// label before its svc or pod label, or of the short name.namespace of mimic
+// mode, or "" if name is not in a namespace, e.g. the name of a PTR query.
+func queryNamespace(name string) string {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	if len(labels) == 2 {
+		return labels[1]
+	}
+	for i := 1; i < len(labels); i++ {
+		if labels[i] == "svc" || labels[i] == "pod" {
+			return labels[i-1]
+		}
+	}
+	return ""
+}
+
+// sameAnswers returns true if a and b hold the same answers in any order.
+func sameAnswers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[string]int)
+	for _, s := range a {
+		count[s]++
+	}
+	for _, s := range b {
+		if count[s] == 0 {
+			return false
+		}
+		count[s]--
+	}
+	return true
+}
+
+// anyAnswer returns true if any of a is one of b.
+func anyAnswer(a, b []string) bool {
+	for _, s := range a {
+		for _, t := range b {
+			if s == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recordLastConverged counts the required resolver name as the last to
+// converge in a verification.
+func recordLastConverged(name string) {
+	if len(verifyResolvers()) > 1 {
+		LastConvergedCount.WithLabelValues(name).Inc()
+	}
+}
+
+// A verification is the outcome of verifying a query.
+type verification struct {
+	verified bool
+	elapsed  time.Duration
+	answers  []string
+}
+
+// verifyQueries concurrently verifies that each of queries is present or
+// absent, recording the outcomes under action.
+func verifyQueries(action string, queries []query, present bool) []verification {
+	results := make([]verification, len(queries))
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		wg.Add(1)
+		go func(i int, q query) {
+			defer wg.Done()
+			defer acquireVerifySlot()()
+			r := &results[i]
+			q.action = action
+			span := startSpan(q.name, "dns.verify", attribute.String("kubernoisy.action", action), attribute.String("dns.name", q.name), attribute.String("dns.type", q.rtype))
+			defer func() {
+				span.SetAttributes(attribute.Bool("kubernoisy.verified", r.verified))
+				span.End()
+			}()
+			reason := "unverified"
+			if present {
+				var mismatch bool
+				r.verified, r.elapsed, r.answers, mismatch = verifyPresent(q)
+				if mismatch {
+					reason = "mismatch"
+				}
+				if r.verified && dnssec && (q.rtype == "IP" || q.rtype == "A" || q.rtype == "AAAA") && !verifyDNSSEC(q) {
+					r.verified, reason = false, "dnssec"
+				}
+				if r.verified && verifySRVFields && q.rtype == "SRV" && !srvFieldsMatch(q) {
+					r.verified, reason = false, "mismatch"
+				}
+			} else {
+				r.verified, r.elapsed, reason = verifyAbsent(q)
+			}
+			recordValidationReason(action, queryNamespace(q.name), q.rtype, r.verified, r.elapsed, reason)
+			debugEvent(logFields{action: action, name: q.name, elapsed: r.elapsed}, "%v %v %v verified %v after %v", action, q.rtype, q.name, r.verified, r.elapsed)
+		}(i, q)
+	}
+	wg.Wait()
+	return results
+}
+
+// verifyDNSSEC validates the DNSSEC signatures of the records of q, recording
+// the validation latency, and returns true if they are valid.
+func verifyDNSSEC(q query) bool {
+	start := clock.Now()
+	err := validateDNSSEC(q.name, q.rtype)
+	result := "valid"
+	if err != nil {
+		result = "invalid"
+		logEvent(logFields{action: q.action, name: q.name, err: err}, "could not validate dnssec of %v %v: %v", q.rtype, q.name, err)
+	}
+	DNSSECValidationDuration.WithLabelValues(result).Observe(clock.Since(start).Seconds())
+	return err == nil
+}
+
+// srvFieldsMatch looks up the SRV records of q again, and returns true if the
+// priority and weight of each are as expected, counting and logging those
+// that are not.
+func srvFieldsMatch(q query) bool {
+	_, srvs, err := resolver.LookupSRV(opsCtx, srvPort(q), "tcp", q.name)
+	if err != nil {
+		debugEvent(logFields{action: q.action, name: q.name, err: err}, "could not look up srv records of %v: %v", q.name, err)
+		return false
+	}
+	weight := srvWeight
+	if weight == 0 && len(srvs) > 0 {
+		// CoreDNS weights the records of a priority to a total of 100, at least 1 each
+		if weight = 100 / len(srvs); weight == 0 {
+			weight = 1
+		}
+	}
+	ok := true
+	for _, s := range srvs {
+		if int(s.Priority) != srvPriority {
+			SRVFieldMismatchCount.WithLabelValues("priority").Inc()
+			logEvent(logFields{action: q.action, name: q.name}, "%v answered %v:%d with priority %d, want %d", q.name, s.Target, s.Port, s.Priority, srvPriority)
+			ok = false
+		}
+		if int(s.Weight) != weight {
+			SRVFieldMismatchCount.WithLabelValues("weight").Inc()
+			logEvent(logFields{action: q.action, name: q.name}, "%v answered %v:%d with weight %d, want %d", q.name, s.Target, s.Port, s.Weight, weight)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// verifySampled returns true if an operation is to be verified, randomly
+// choosing the verify sample rate fraction of operations.
+func verifySampled() bool {
+	return verifySampleRate >= 1 || rand.Float64() < verifySampleRate
+}
+
+// jitteredTimeout returns the verification timeout of q, randomly adjusted by
+// up to the timeout jitter fraction so that verifications started together
+// during an outage do not all give up at once.
+func jitteredTimeout(q query) time.Duration {
+	timeout := q.timeout
+	if timeout == 0 {
+		timeout = workloadOf(q.name).timeout
+	}
+	if timeoutJitter == 0 {
+		return timeout
+	}
+	return time.Duration(float64(timeout) * (1 + timeoutJitter*(2*rand.Float64()-1)))
+}
+
+// verifySlots limits the number of concurrent verifications, if set.
+var verifySlots chan struct{}
+
+// acquireVerifySlot blocks until a verification may start, and returns the
+// function releasing the slot.
+func acquireVerifySlot() func() {
+	if verifySlots == nil {
+		return func() {}
+	}
+	verifySlots <- struct{}{}
+	return func() { <-verifySlots }
+}
+
+// recordValidation records the outcome of verifying action on a record type
+// in the namespace ns.
+func recordValidation(action, ns, rtype string, verified bool, elapsed time.Duration) {
+	recordValidationReason(action, ns, rtype, verified, elapsed, "unverified")
+}
+
+// recordValidationReason records the outcome of verifying action on a record
+// type in the namespace ns, counting a failure by reason.
+func recordValidationReason(action, ns, rtype string, verified bool, elapsed time.Duration, reason string) {
+	if recordDrained(verified) {
+		return
+	}
+	if !verified {
+		ValidationFailCount.WithLabelValues(action, rtype, familyLabel(), reason, ns, validationMethod()).Inc()
+		sampleFailure(action + "/" + rtype)
+		return
+	}
+	ValidationDuration.WithLabelValues(action, rtype, familyLabel(), ns, validationMethod()).Observe(elapsed.Seconds())
+	sampleLatency(action+"/"+rtype, elapsed)
+	sampleRecent(elapsed)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	bucketLayout, bucketStart, bucketWidth, bucketCount = "linear", 0, 1, 10
+	registerLatencyMetrics()
+	os.Exit(m.Run())
+}
+
+// fakeResolver answers with its address from when it appears until when it is
+// gone on the clock, if set, and as not found otherwise.
+type fakeResolver struct {
+	addr         string
+	appear, gone time.Time
+	neverAppears bool
+	noData       bool // answers without records instead of as not found
+	lookups      int
+}
+
+func (r *fakeResolver) answering() bool {
+	now := clock.Now()
+	if r.neverAppears || now.Before(r.appear) {
+		return false
+	}
+	return r.gone.IsZero() || now.Before(r.gone)
+}
+
+func (r *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	r.lookups++
+	if !r.answering() {
+		if r.noData {
+			return nil, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []net.IPAddr{{IP: net.ParseIP(r.addr)}}, nil
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, err := r.LookupIPAddr(ctx, host)
+	var hosts []string
+	for _, a := range addrs {
+		hosts = append(hosts, a.IP.String())
+	}
+	return hosts, err
+}
+
+func (r *fakeResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+// useFakeResolver verifies with r, polling every second without jitter and
+// requiring all wanted answers, for the test t.
+func useFakeResolver(t *testing.T, r dnsResolver) {
+	prevResolver, prevPoll, prevJitter, prevQuorum := resolver, pollInterval, timeoutJitter, endpointQuorum
+	resolver, pollInterval, timeoutJitter, endpointQuorum = r, time.Second, 0, 1
+	t.Cleanup(func() {
+		resolver, pollInterval, timeoutJitter, endpointQuorum = prevResolver, prevPoll, prevJitter, prevQuorum
+	})
+}
+
+func TestVerifyPresent(t *testing.T) {
+	tests := []struct {
+		name         string
+		appearAfter  time.Duration
+		neverAppears bool
+		want         []string
+		verified     bool
+		elapsed      time.Duration
+		mismatch     bool
+	}{
+		{name: "resolves at once", verified: true},
+		{name: "resolves after 3s", appearAfter: 3 * time.Second, verified: true, elapsed: 3 * time.Second},
+		{name: "times out", neverAppears: true, elapsed: 10 * time.Second},
+		{name: "answered but not as wanted", want: []string{"10.0.0.2"}, elapsed: 10 * time.Second, mismatch: true},
+		{name: "answered as wanted after 4s", appearAfter: 4 * time.Second, want: []string{"10.0.0.1"}, verified: true, elapsed: 4 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeClock(t)
+			r := &fakeResolver{addr: "10.0.0.1", appear: f.Now().Add(tt.appearAfter), neverAppears: tt.neverAppears}
+			useFakeResolver(t, r)
+			q := query{rtype: "IP", name: "svc.ns.svc.cluster.local.", want: tt.want, timeout: 10 * time.Second}
+
+			var verified, mismatch bool
+			var elapsed time.Duration
+			var answers []string
+			f.runAdvancing(pollInterval, func() { verified, elapsed, answers, mismatch = verifyPresent(q) })
+			if verified != tt.verified || elapsed != tt.elapsed || mismatch != tt.mismatch {
+				t.Errorf("verifyPresent = %v, %v, mismatch %v; want %v, %v, mismatch %v", verified, elapsed, mismatch, tt.verified, tt.elapsed, tt.mismatch)
+			}
+			if verified && (len(answers) != 1 || answers[0] != "10.0.0.1") {
+				t.Errorf("answers = %v, want [10.0.0.1]", answers)
+			}
+		})
+	}
+}
+
+func TestVerifyPresentNoRetry(t *testing.T) {
+	f := useFakeClock(t)
+	r := &fakeResolver{addr: "10.0.0.1", appear: f.Now().Add(time.Second)}
+	useFakeResolver(t, r)
+	noRetryVerify = true
+	defer func() { noRetryVerify = false }()
+
+	var verified bool
+	var elapsed time.Duration
+	f.runAdvancing(pollInterval, func() {
+		verified, elapsed, _, _ = verifyPresent(query{rtype: "IP", name: "svc.", timeout: 10 * time.Second})
+	})
+	if verified || elapsed != 0 || r.lookups != 1 {
+		t.Errorf("verifyPresent = %v, %v after %d lookups, want false, 0 after 1 lookup", verified, elapsed, r.lookups)
+	}
+}
+
+func TestVerifyAbsent(t *testing.T) {
+	tests := []struct {
+		name      string
+		goneAfter time.Duration
+		neverGone bool
+		noData    bool
+		verified  bool
+		elapsed   time.Duration
+		reason    string
+	}{
+		{name: "gone at once", verified: true},
+		{name: "gone after 2s", goneAfter: 2 * time.Second, verified: true, elapsed: 2 * time.Second},
+		{name: "no records of its type after 3s", goneAfter: 3 * time.Second, noData: true, verified: true, elapsed: 3 * time.Second},
+		{name: "still served at the timeout", neverGone: true, elapsed: 5 * time.Second, reason: "unverified"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeClock(t)
+			r := &fakeResolver{addr: "10.0.0.1", appear: f.Now(), noData: tt.noData}
+			if !tt.neverGone {
+				r.gone = f.Now().Add(tt.goneAfter)
+			}
+			useFakeResolver(t, r)
+			q := query{rtype: "IP", name: "svc.ns.svc.cluster.local.", timeout: 5 * time.Second}
+
+			var verified bool
+			var elapsed time.Duration
+			var reason string
+			f.runAdvancing(pollInterval, func() { verified, elapsed, reason = verifyAbsent(q) })
+			if verified != tt.verified || elapsed != tt.elapsed || reason != tt.reason {
+				t.Errorf("verifyAbsent = %v, %v, %q; want %v, %v, %q", verified, elapsed, reason, tt.verified, tt.elapsed, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// endpointTimes tracks services waiting for ready endpoints, by name, with
+// the time their objects were created, and those whose endpoints are ready,
+// with the time they became ready.
+var endpointTimes = struct {
+	sync.Mutex
+	pending map[string]time.Time
+	ready   map[string]time.Time
+}{pending: make(map[string]time.Time), ready: make(map[string]time.Time)}
+
+// expectEndpoints starts timing the service name becoming ready in the
+// watched endpoints API.
+func expectEndpoints(name string) {
+	if !watchEndpointsAPI {
+		return
+	}
+	endpointTimes.Lock()
+	endpointTimes.pending[name] = clock.Now()
+	endpointTimes.Unlock()
+}
+
+// forgetEndpoints stops timing the service name, e.g. once it is deleted.
+func forgetEndpoints(name string) {
+	if !watchEndpointsAPI {
+		return
+	}
+	endpointTimes.Lock()
+	delete(endpointTimes.pending, name)
+	delete(endpointTimes.ready, name)
+	endpointTimes.Unlock()
+}
+
+// observeEndpoints records the time for the service name to become ready, once
+// its watched endpoints have a ready address for each replica.
+func observeEndpoints(api, name string, ready int) {
+	if ready < replicas {
+		return
+	}
+	endpointTimes.Lock()
+	start, ok := endpointTimes.pending[name]
+	delete(endpointTimes.pending, name)
+	if ok {
+		endpointTimes.ready[name] = clock.Now()
+	}
+	endpointTimes.Unlock()
+	if ok {
+		EndpointsReadyDuration.WithLabelValues(api).Observe(clock.Since(start).Seconds())
+	}
+}
+
+// recordDNSAfterEndpoints records the time from the watched endpoints of the
+// service name becoming ready to its record converging at converged,
+// attributing the rest of its add propagation delay to DNS. Records converging
+// before the watch saw the endpoints ready count as no delay.
+func recordDNSAfterEndpoints(name string, converged time.Time) {
+	endpointTimes.Lock()
+	ready, ok := endpointTimes.ready[name]
+	endpointTimes.Unlock()
+	if !ok {
+		return
+	}
+	d := converged.Sub(ready)
+	if d < 0 {
+		d = 0
+	}
+	DNSAfterEndpointsDuration.Observe(d.Seconds())
+}
+
+// detectEndpointsAPI returns endpointslice if the server serves EndpointSlices,
+// otherwise endpoints.
+func detectEndpointsAPI(kapi kubernetes.Interface) string {
+	if _, err := kapi.Discovery().ServerResourcesForGroupVersion(discovery.SchemeGroupVersion.String()); err == nil {
+		return "endpointslice"
+	}
+	return "endpoints"
+}
+
+// watchEndpoints watches api, endpoints or endpointslice, in the namespace for
+// services becoming ready, until stop is closed.
+func watchEndpoints(kapi kubernetes.Interface, api string, stop <-chan struct{}) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(kapi, 0, informers.WithNamespace(namespace))
+	var informer cache.SharedIndexInformer
+	var handle func(obj interface{})
+	switch api {
+	case "endpoints":
+		informer = factory.Core().V1().Endpoints().Informer()
+		handle = func(obj interface{}) {
+			ep, ok := obj.(*v1.Endpoints)
+			if !ok {
+				return
+			}
+			ready := 0
+			for _, s := range ep.Subsets {
+				ready += len(s.Addresses)
+			}
+			observeEndpoints(api, ep.Name, ready)
+		}
+	case "endpointslice":
+		informer = factory.Discovery().V1beta1().EndpointSlices().Informer()
+		handle = func(obj interface{}) {
+			slice, ok := obj.(*discovery.EndpointSlice)
+			if !ok {
+				return
+			}
+			ready := 0
+			for _, e := range slice.Endpoints {
+				if e.Conditions.Ready == nil || *e.Conditions.Ready {
+					ready++
+				}
+			}
+			observeEndpoints(api, slice.Labels[discovery.LabelServiceName], ready)
+		}
+	default:
+		return fmt.Errorf("unknown endpoints-api %q", api)
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    handle,
+		UpdateFunc: func(_, obj interface{}) { handle(obj) },
+	})
+	factory.Start(stop)
+	if !cache.WaitForCacheSync(stop, informer.HasSynced) {
+		return fmt.Errorf("could not sync %v informer", api)
+	}
+	return nil
+}
+
+// watchDNSConfig watches the configmap ref, given as "namespace/name" or just
+// "name" in kube-system, counting changes of its data as DNS config reloads,
+// until stop is closed.
+func watchDNSConfig(kapi kubernetes.Interface, ref string, stop <-chan struct{}) error {
+	ns, name := "kube-system", ref
+	if i := strings.Index(ref, "/"); i >= 0 {
+		ns, name = ref[:i], ref[i+1:]
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(kapi, 0, informers.WithNamespace(ns),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) { o.FieldSelector = "metadata.name=" + name }))
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, obj interface{}) {
+			old, ok := oldObj.(*v1.ConfigMap)
+			if !ok {
+				return
+			}
+			cm, ok := obj.(*v1.ConfigMap)
+			if !ok || reflect.DeepEqual(old.Data, cm.Data) {
+				return
+			}
+			CoreDNSReloadCount.Inc()
+			logEvent(logFields{action: "watch", object: "configmap", name: name, namespace: ns}, "DNS config %v.%v changed", name, ns)
+		},
+	})
+	factory.Start(stop)
+	if !cache.WaitForCacheSync(stop, informer.HasSynced) {
+		return fmt.Errorf("could not sync configmap %v.%v informer", name, ns)
+	}
+	return nil
+}